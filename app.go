@@ -26,9 +26,12 @@ type App struct {
 	groupnameReplaces []ReplacementPair
 	removeLimit       int
 	banDuration       time.Duration
+	// disabledUserRemoveDuration is zero if disabled users are kept banned.
+	disabledUserRemoveDuration time.Duration
 
 	ytsaurus *Ytsaurus
 	source   Source
+	clock    clock.PassiveClock
 
 	stopCh chan struct{}
 	sigCh  chan os.Signal
@@ -43,10 +46,18 @@ func NewApp(cfg *Config, logger appLoggerType) (*App, error) {
 	var err error
 	var source Source
 	if cfg.Azure != nil {
-		source, err = NewAzureReal(cfg.Azure, logger)
+		azure, err := NewAzureReal(cfg.Azure, logger)
 		if err != nil {
 			return nil, err
 		}
+		if cfg.Azure.Delta != nil && cfg.Azure.Delta.StateYtsaurusPath != "" {
+			// Delta state is kept in the same cluster identities are synced to.
+			azure.delta.store, err = newAzureDeltaYtsaurusStore(cfg.Azure.Delta.StateYtsaurusPath, &cfg.Ytsaurus)
+			if err != nil {
+				return nil, err
+			}
+		}
+		source = azure
 	}
 
 	if cfg.Ldap != nil {
@@ -76,8 +87,11 @@ func NewAppCustomized(cfg *Config, logger appLoggerType, source Source, clock cl
 		removeLimit:       cfg.App.RemoveLimit,
 		banDuration:       cfg.App.BanBeforeRemoveDuration,
 
+		disabledUserRemoveDuration: cfg.App.DisabledUserRemoveDuration,
+
 		ytsaurus: yt,
 		source:   source,
+		clock:    clock,
 
 		stopCh: make(chan struct{}),
 		sigCh:  sigCh,
@@ -305,10 +305,13 @@ func (suite *AppTestSuite) TestLdapSyncOnce() {
 
 					ldapConfig, err := ldapLocal.GetConfig()
 					require.NoError(t, err)
+					t.Setenv(ldapConfig.BindPasswordEnvVar, "adminpassword")
 					ldapSource, err := NewLdap(ldapConfig, getDevelopmentLogger())
 					require.NoError(t, err)
 
-					setupLdapObjects(t, ldapSource.connection, tc.sourceUsersSetUp, tc.sourceGroupsSetUp)
+					ldapConn, err := ldapSource.connection.get()
+					require.NoError(t, err)
+					setupLdapObjects(t, ldapConn, tc.sourceUsersSetUp, tc.sourceGroupsSetUp)
 
 					setupYtsaurusObjects(
 						t,
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	msgraphserviceprincipals "github.com/microsoftgraph/msgraph-sdk-go/serviceprincipals"
+	"github.com/pkg/errors"
+)
+
+const (
+	// azureDefaultAccessAppRoleID is id of the role of assignments to applications, which don't define roles.
+	azureDefaultAccessAppRoleID = "00000000-0000-0000-0000-000000000000"
+
+	azureUserPrincipalType             = "User"
+	azureGroupPrincipalType            = "Group"
+	azureServicePrincipalPrincipalType = "ServicePrincipal"
+)
+
+// getAppRolesWithMembers returns app roles of the configured enterprise application as groups.
+// Members of a role are users and service principals assigned to it directly or via assigned groups.
+// https://learn.microsoft.com/en-us/entra/identity-platform/howto-add-app-roles-in-apps
+func (a *AzureReal) getAppRolesWithMembers(ctx context.Context) ([]SourceGroupWithMembers, error) {
+	servicePrincipalID := a.appRoles.ServicePrincipalID
+	configuration := &msgraphserviceprincipals.ServicePrincipalItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphserviceprincipals.ServicePrincipalItemRequestBuilderGetQueryParameters{
+			Select: []string{"id", "displayName", "appRoles"},
+		},
+	}
+	servicePrincipal, err := a.graphClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalID).Get(ctx, configuration)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get service principal %s", servicePrincipalID)
+	}
+	assignments, err := a.getAppRoleAssignments(ctx, servicePrincipalID)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []SourceGroupWithMembers
+	// roleIndexes are indexes of groups by role ids, skipped roles have -1.
+	roleIndexes := make(map[string]int)
+	addRole := func(id, name string) {
+		roleIndexes[id] = -1
+		a.maybePrintDebugLogs(id, "displayName", name)
+		if name == "" {
+			a.logger.Debugw("Skipping app role with empty name", "id", id)
+			return
+		}
+		if a.groupsDisplayNameRegexPostFilter != nil && !a.groupsDisplayNameRegexPostFilter.MatchString(name) {
+			return
+		}
+		roleIndexes[id] = len(groups)
+		groups = append(groups, SourceGroupWithMembers{
+			SourceGroup: AzureGroup{
+				AzureID:     id,
+				DisplayName: name,
+			},
+			Members: NewStringSet(),
+		})
+	}
+	for _, role := range servicePrincipal.GetAppRoles() {
+		if role.GetId() == nil || (role.GetIsEnabled() != nil && !*role.GetIsEnabled()) {
+			continue
+		}
+		// Value is the role name used in tokens, display name is shown in the portal.
+		name := handleNil(role.GetValue())
+		if name == "" {
+			name = handleNil(role.GetDisplayName())
+		}
+		addRole(role.GetId().String(), name)
+	}
+
+	// groupRoles are indexes of roles by ids of assigned groups, members of groups are fetched separately.
+	groupRoles := make(map[string][]int)
+	var groupIDs []string
+	for _, assignment := range assignments {
+		if assignment.GetAppRoleId() == nil || assignment.GetPrincipalId() == nil {
+			continue
+		}
+		roleID := assignment.GetAppRoleId().String()
+		principalID := assignment.GetPrincipalId().String()
+		if _, ok := roleIndexes[roleID]; !ok && roleID == azureDefaultAccessAppRoleID {
+			// Default access is named after the application.
+			addRole(roleID, handleNil(servicePrincipal.GetDisplayName()))
+		}
+		index, ok := roleIndexes[roleID]
+		if !ok || index < 0 {
+			// Role is disabled, removed or filtered out.
+			continue
+		}
+		switch handleNil(assignment.GetPrincipalType()) {
+		case azureUserPrincipalType:
+			groups[index].Members.Add(principalID)
+		case azureServicePrincipalPrincipalType:
+			if a.servicePrincipals != nil {
+				groups[index].Members.Add(principalID)
+			}
+		case azureGroupPrincipalType:
+			if _, ok := groupRoles[principalID]; !ok {
+				groupIDs = append(groupIDs, principalID)
+			}
+			groupRoles[principalID] = append(groupRoles[principalID], index)
+		}
+	}
+
+	// Roles are granted to direct members of assigned groups, nested groups are expanded only with transitive members.
+	groupsMembers := make([][]models.DirectoryObjectable, len(groupIDs))
+	err = runConcurrently(ctx, a.concurrency, len(groupIDs), func(ctx context.Context, i int) error {
+		members, err := a.getGroupMembers(ctx, groupIDs[i])
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch members of group %s", groupIDs[i])
+		}
+		groupsMembers[i] = members
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, groupID := range groupIDs {
+		for _, member := range groupsMembers[i] {
+			if !a.isAccount(member) || member.GetId() == nil {
+				continue
+			}
+			for _, index := range groupRoles[groupID] {
+				groups[index].Members.Add(*member.GetId())
+			}
+		}
+	}
+
+	for _, group := range groups {
+		a.maybePrintDebugLogs(group.SourceGroup.GetID(), "azure_members_count", group.Members.Cardinality())
+	}
+	a.logger.Infow("Fetched app roles from Azure AD", "roles", len(groups), "assignments", len(assignments))
+	return groups, nil
+}
+
+// getAppRoleAssignments returns assignments of users, groups and service principals to the application roles.
+func (a *AzureReal) getAppRoleAssignments(ctx context.Context, servicePrincipalID string) ([]models.AppRoleAssignmentable, error) {
+	// https://learn.microsoft.com/en-us/graph/api/serviceprincipal-list-approleassignedto
+	configuration := &msgraphserviceprincipals.ItemAppRoleAssignedToRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphserviceprincipals.ItemAppRoleAssignedToRequestBuilderGetQueryParameters{
+			Select: []string{"id", "appRoleId", "principalId", "principalType"},
+		},
+	}
+	result, err := a.graphClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalID).AppRoleAssignedTo().Get(ctx, configuration)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get app role assignments of service principal %s", servicePrincipalID)
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.AppRoleAssignmentable](
+		result,
+		a.graphClient.GetAdapter(),
+		models.CreateAppRoleAssignmentCollectionResponseFromDiscriminatorValue,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create app role assignments page iterator")
+	}
+
+	var assignments []models.AppRoleAssignmentable
+	err = pageIterator.Iterate(ctx, func(assignment models.AppRoleAssignmentable) bool {
+		assignments = append(assignments, assignment)
+		// Return true to continue the iteration.
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to iterate over Azure app role assignments")
+	}
+	return assignments, nil
+}
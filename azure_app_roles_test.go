@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzureAppRoles(t *testing.T) {
+	// Ids of assignments are GUIDs in MS Graph, so the test objects have GUID ids too.
+	const (
+		app     = "00000000-0000-0000-0000-0000000000a0"
+		ci      = "00000000-0000-0000-0000-0000000000a1"
+		alice   = "00000000-0000-0000-0000-000000000001"
+		bob     = "00000000-0000-0000-0000-000000000002"
+		carol   = "00000000-0000-0000-0000-000000000003"
+		dave    = "00000000-0000-0000-0000-000000000004"
+		devs    = "00000000-0000-0000-0000-0000000000f1"
+		nested  = "00000000-0000-0000-0000-0000000000f2"
+		admin   = "00000000-0000-0000-0000-0000000000e1"
+		reader  = "00000000-0000-0000-0000-0000000000e2"
+		retired = "00000000-0000-0000-0000-0000000000e3"
+	)
+	server := newFakeGraphServer(t)
+	server.pageSize = 2
+	server.addServicePrincipal(app, "YTsaurus")
+	server.addServicePrincipal(ci, "CI")
+	for _, id := range []string{alice, bob, carol, dave} {
+		server.addUser(id, id+"@acme.com")
+	}
+	server.addGroup(nested, "nested", dave)
+	server.addGroup(devs, "devs", bob, carol, nested)
+	server.addAppRole(app, admin, "yt-admins", true)
+	server.addAppRole(app, reader, "yt-readers", true)
+	server.addAppRole(app, retired, "yt-retired", false)
+	server.assignAppRole(app, admin, alice)
+	server.assignAppRole(app, admin, devs)
+	server.assignAppRole(app, reader, devs)
+	server.assignAppRole(app, reader, ci)
+	server.assignAppRole(app, retired, dave)
+	server.assignAppRole(app, azureDefaultAccessAppRoleID, alice)
+
+	// Roles are granted to direct members of assigned groups only, service principals aren't synced by default.
+	azure := newFakeGraphAzure(t, server, &AzureConfig{AppRoles: &AzureAppRolesConfig{ServicePrincipalID: app}})
+	groups, err := azure.GetGroupsWithMembers()
+	require.NoError(t, err)
+	var ids []string
+	for _, group := range groups {
+		ids = append(ids, group.SourceGroup.GetID())
+		require.Nil(t, group.Managers)
+	}
+	require.Equal(t, []string{admin, reader, azureDefaultAccessAppRoleID}, ids)
+	require.Equal(t, map[string]StringSet{
+		"yt-admins":  NewStringSetFromItems(alice, bob, carol),
+		"yt-readers": NewStringSetFromItems(bob, carol),
+		"YTsaurus":   NewStringSetFromItems(alice),
+	}, getAzureGroupMembers(t, azure))
+
+	azure = newFakeGraphAzure(t, server, &AzureConfig{
+		AppRoles:                         &AzureAppRolesConfig{ServicePrincipalID: app},
+		ServicePrincipals:                &AzureServicePrincipalsConfig{},
+		TransitiveMembers:                true,
+		GroupsDisplayNameRegexPostFilter: "^yt-",
+	})
+	require.Equal(t, map[string]StringSet{
+		"yt-admins":  NewStringSetFromItems(alice, bob, carol, dave),
+		"yt-readers": NewStringSetFromItems(bob, carol, dave, ci),
+	}, getAzureGroupMembers(t, azure))
+}
+
+func TestAzureAppRolesErrors(t *testing.T) {
+	server := newFakeGraphServer(t)
+	_, err := newAzureRealWithClient(&AzureConfig{AppRoles: &AzureAppRolesConfig{}}, getDevelopmentLogger(), nil)
+	require.ErrorContains(t, err, "service_principal_id is required")
+
+	azure := newFakeGraphAzure(t, server, &AzureConfig{AppRoles: &AzureAppRolesConfig{ServicePrincipalID: "missing"}})
+	_, err = azure.GetGroupsWithMembers()
+	require.ErrorContains(t, err, "failed to get service principal missing")
+}
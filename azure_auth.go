@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/pkg/errors"
+)
+
+const (
+	azureAuthMethodClientSecret      = "client_secret"
+	azureAuthMethodClientCertificate = "client_certificate"
+	azureAuthMethodManagedIdentity   = "managed_identity"
+	azureAuthMethodWorkloadIdentity  = "workload_identity"
+)
+
+// azureCredentialOptions are passed to credentials of all methods, tests point them to the stub token endpoint.
+type azureCredentialOptions struct {
+	clientOptions            azcore.ClientOptions
+	disableInstanceDiscovery bool
+}
+
+// newAzureCredential creates credential for the configured auth method.
+// https://learn.microsoft.com/en-us/graph/sdks/choose-authentication-providers
+func newAzureCredential(cfg *AzureConfig, options azureCredentialOptions) (azcore.TokenCredential, error) {
+	auth := cfg.Auth
+	if auth == nil {
+		auth = &AzureAuthConfig{}
+	}
+	switch auth.Method {
+	case "", azureAuthMethodClientSecret:
+		if cfg.ClientSecretEnvVar == "" {
+			cfg.ClientSecretEnvVar = defaultAzureSecretEnvVar
+		}
+		secret := os.Getenv(cfg.ClientSecretEnvVar)
+		if secret == "" {
+			return nil, errors.Errorf("Azure secret in %s env var shouldn't be empty", cfg.ClientSecretEnvVar)
+		}
+		cred, err := azidentity.NewClientSecretCredential(cfg.Tenant, cfg.ClientID, secret, &azidentity.ClientSecretCredentialOptions{
+			ClientOptions:            options.clientOptions,
+			DisableInstanceDiscovery: options.disableInstanceDiscovery,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create Azure secret credentials")
+		}
+		return cred, nil
+
+	case azureAuthMethodClientCertificate:
+		if auth.CertificateFile == "" {
+			return nil, errors.Errorf("auth certificate_file is required for %s method", auth.Method)
+		}
+		data, err := os.ReadFile(auth.CertificateFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read Azure certificate file %s", auth.CertificateFile)
+		}
+		var password []byte
+		if auth.CertificatePasswordEnvVar != "" {
+			password = []byte(os.Getenv(auth.CertificatePasswordEnvVar))
+		}
+		// PEM and PFX (PKCS#12) formats are detected automatically.
+		certs, key, err := azidentity.ParseCertificates(data, password)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse Azure certificate file %s", auth.CertificateFile)
+		}
+		cred, err := azidentity.NewClientCertificateCredential(cfg.Tenant, cfg.ClientID, certs, key, &azidentity.ClientCertificateCredentialOptions{
+			ClientOptions:            options.clientOptions,
+			DisableInstanceDiscovery: options.disableInstanceDiscovery,
+			SendCertificateChain:     auth.SendCertificateChain,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create Azure certificate credentials")
+		}
+		return cred, nil
+
+	case azureAuthMethodManagedIdentity:
+		credOptions := &azidentity.ManagedIdentityCredentialOptions{
+			ClientOptions: options.clientOptions,
+		}
+		if auth.ManagedIdentityClientID != "" {
+			credOptions.ID = azidentity.ClientID(auth.ManagedIdentityClientID)
+		}
+		cred, err := azidentity.NewManagedIdentityCredential(credOptions)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create Azure managed identity credentials")
+		}
+		return cred, nil
+
+	case azureAuthMethodWorkloadIdentity:
+		// Empty values are taken from AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_FEDERATED_TOKEN_FILE env vars,
+		// which are set by AKS workload identity webhook. Token file is reread when it is rotated.
+		cred, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions:            options.clientOptions,
+			DisableInstanceDiscovery: options.disableInstanceDiscovery,
+			TenantID:                 cfg.Tenant,
+			ClientID:                 cfg.ClientID,
+			TokenFilePath:            auth.FederatedTokenFile,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create Azure workload identity credentials")
+		}
+		return cred, nil
+
+	default:
+		return nil, errors.Errorf("unknown Azure auth method %q", auth.Method)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	fakeAzureTenant   = "acme.onmicrosoft.com"
+	fakeAzureClientID = "abcdefgh-a000-b111-c222-abcdef123456"
+)
+
+// fakeAzureTokenServer is a stub of Azure AD token endpoint and of App Service managed identity endpoint.
+type fakeAzureTokenServer struct {
+	server *httptest.Server
+
+	mu sync.Mutex
+	// requests are form values of token requests and query values of managed identity requests.
+	requests []url.Values
+}
+
+func newFakeAzureTokenServer(t *testing.T) *fakeAzureTokenServer {
+	s := &fakeAzureTokenServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+fakeAzureTenant+"/v2.0/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		authority := s.server.URL + "/" + fakeAzureTenant
+		s.writeJSON(w, map[string]any{
+			"token_endpoint":         authority + "/oauth2/v2.0/token",
+			"authorization_endpoint": authority + "/oauth2/v2.0/authorize",
+			"issuer":                 authority + "/v2.0",
+		})
+	})
+	mux.HandleFunc("/"+fakeAzureTenant+"/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		s.addRequest(r.PostForm)
+		s.writeJSON(w, map[string]any{
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"access_token": "token-" + r.PostForm.Get("client_id"),
+		})
+	})
+	mux.HandleFunc("/msi/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-IDENTITY-HEADER") != "identity-header" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		s.addRequest(r.URL.Query())
+		s.writeJSON(w, map[string]any{
+			"token_type":   "Bearer",
+			"expires_on":   strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+			"resource":     r.URL.Query().Get("resource"),
+			"access_token": "token-managed-identity",
+		})
+	})
+	s.server = httptest.NewTLSServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *fakeAzureTokenServer) addRequest(values url.Values) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, values)
+}
+
+func (s *fakeAzureTokenServer) getRequests() []url.Values {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]url.Values(nil), s.requests...)
+}
+
+func (s *fakeAzureTokenServer) writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (s *fakeAzureTokenServer) credentialOptions() azureCredentialOptions {
+	return azureCredentialOptions{
+		clientOptions: azcore.ClientOptions{
+			Cloud: cloud.Configuration{
+				ActiveDirectoryAuthorityHost: s.server.URL + "/",
+				Services:                     map[cloud.ServiceName]cloud.ServiceConfiguration{},
+			},
+			Transport: s.server.Client(),
+		},
+		disableInstanceDiscovery: true,
+	}
+}
+
+func getFakeAzureToken(t *testing.T, server *fakeAzureTokenServer, cfg *AzureConfig) string {
+	cred, err := newAzureCredential(cfg, server.credentialOptions())
+	require.NoError(t, err)
+	token, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{scope}})
+	require.NoError(t, err)
+	return token.Token
+}
+
+// writeTestAzureCertificate writes self-signed RSA certificate and its key into PEM file.
+func writeTestAzureCertificate(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "cert.pem")
+	content := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})...,
+	)
+	require.NoError(t, os.WriteFile(path, content, 0600))
+	return path
+}
+
+func TestAzureAuthClientSecret(t *testing.T) {
+	server := newFakeAzureTokenServer(t)
+	t.Setenv("AZURE_TEST_SECRET", "secret")
+	token := getFakeAzureToken(t, server, &AzureConfig{
+		Tenant:             fakeAzureTenant,
+		ClientID:           fakeAzureClientID,
+		ClientSecretEnvVar: "AZURE_TEST_SECRET",
+		Auth:               &AzureAuthConfig{Method: azureAuthMethodClientSecret},
+	})
+	require.Equal(t, "token-"+fakeAzureClientID, token)
+
+	requests := server.getRequests()
+	require.Len(t, requests, 1)
+	require.Equal(t, "client_credentials", requests[0].Get("grant_type"))
+	require.Equal(t, "secret", requests[0].Get("client_secret"))
+	require.Contains(t, strings.Fields(requests[0].Get("scope")), scope)
+
+	// Empty secret is an error.
+	_, err := newAzureCredential(&AzureConfig{ClientSecretEnvVar: "AZURE_TEST_MISSING_SECRET"}, server.credentialOptions())
+	require.ErrorContains(t, err, "AZURE_TEST_MISSING_SECRET")
+}
+
+func TestAzureAuthClientCertificate(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		certificateFile string
+		password        string
+	}{
+		{name: "pem", certificateFile: writeTestAzureCertificate(t)},
+		{name: "pfx", certificateFile: "testdata/azure_client_certificate.pfx", password: "password"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeAzureTokenServer(t)
+			t.Setenv("AZURE_TEST_CERTIFICATE_PASSWORD", tc.password)
+			token := getFakeAzureToken(t, server, &AzureConfig{
+				Tenant:   fakeAzureTenant,
+				ClientID: fakeAzureClientID,
+				Auth: &AzureAuthConfig{
+					Method:                    azureAuthMethodClientCertificate,
+					CertificateFile:           tc.certificateFile,
+					CertificatePasswordEnvVar: "AZURE_TEST_CERTIFICATE_PASSWORD",
+				},
+			})
+			require.Equal(t, "token-"+fakeAzureClientID, token)
+
+			requests := server.getRequests()
+			require.Len(t, requests, 1)
+			require.Equal(t, "client_credentials", requests[0].Get("grant_type"))
+			require.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", requests[0].Get("client_assertion_type"))
+			require.NotEmpty(t, requests[0].Get("client_assertion"))
+			require.Empty(t, requests[0].Get("client_secret"))
+		})
+	}
+}
+
+func TestAzureAuthClientCertificateErrors(t *testing.T) {
+	server := newFakeAzureTokenServer(t)
+	_, err := newAzureCredential(&AzureConfig{
+		Auth: &AzureAuthConfig{Method: azureAuthMethodClientCertificate},
+	}, server.credentialOptions())
+	require.ErrorContains(t, err, "certificate_file is required")
+
+	_, err = newAzureCredential(&AzureConfig{
+		Auth: &AzureAuthConfig{
+			Method:          azureAuthMethodClientCertificate,
+			CertificateFile: "testdata/azure_client_certificate.pfx",
+		},
+	}, server.credentialOptions())
+	require.ErrorContains(t, err, "failed to parse Azure certificate file")
+}
+
+func TestAzureAuthManagedIdentity(t *testing.T) {
+	server := newFakeAzureTokenServer(t)
+	// App Service managed identity endpoint is configured by env vars.
+	t.Setenv("IDENTITY_ENDPOINT", server.server.URL+"/msi/token")
+	t.Setenv("IDENTITY_HEADER", "identity-header")
+
+	token := getFakeAzureToken(t, server, &AzureConfig{
+		Auth: &AzureAuthConfig{
+			Method:                  azureAuthMethodManagedIdentity,
+			ManagedIdentityClientID: "managed-identity-client-id",
+		},
+	})
+	require.Equal(t, "token-managed-identity", token)
+
+	requests := server.getRequests()
+	require.Len(t, requests, 1)
+	require.Equal(t, "managed-identity-client-id", requests[0].Get("client_id"))
+	require.Equal(t, "https://graph.microsoft.com", requests[0].Get("resource"))
+}
+
+func TestAzureAuthWorkloadIdentity(t *testing.T) {
+	server := newFakeAzureTokenServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("federated-token"), 0600))
+
+	token := getFakeAzureToken(t, server, &AzureConfig{
+		Tenant:   fakeAzureTenant,
+		ClientID: fakeAzureClientID,
+		Auth: &AzureAuthConfig{
+			Method:             azureAuthMethodWorkloadIdentity,
+			FederatedTokenFile: tokenFile,
+		},
+	})
+	require.Equal(t, "token-"+fakeAzureClientID, token)
+
+	requests := server.getRequests()
+	require.Len(t, requests, 1)
+	require.Equal(t, "client_credentials", requests[0].Get("grant_type"))
+	require.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", requests[0].Get("client_assertion_type"))
+	require.Equal(t, "federated-token", requests[0].Get("client_assertion"))
+}
+
+func TestAzureAuthUnknownMethod(t *testing.T) {
+	_, err := newAzureCredential(&AzureConfig{Auth: &AzureAuthConfig{Method: "password"}}, azureCredentialOptions{})
+	require.ErrorContains(t, err, `unknown Azure auth method "password"`)
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoft/kiota-abstractions-go/serialization"
+	jsonserialization "github.com/microsoft/kiota-serialization-json-go"
+	msgraphgroups "github.com/microsoftgraph/msgraph-sdk-go/groups"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+	msgraphusers "github.com/microsoftgraph/msgraph-sdk-go/users"
+	"github.com/pkg/errors"
+	"k8s.io/utils/clock"
+
+	"go.ytsaurus.tech/yt/go/ypath"
+	"go.ytsaurus.tech/yt/go/yt"
+)
+
+const (
+	defaultAzureDeltaFullSyncInterval = 24 * time.Hour
+
+	azureUserODataType             = "#microsoft.graph.user"
+	azureGroupODataType            = "#microsoft.graph.group"
+	azureServicePrincipalODataType = "#microsoft.graph.servicePrincipal"
+)
+
+// azureDeltaErrorMapping is the default MS Graph error mapping, except 410 Gone, which requires full resync.
+var azureDeltaErrorMapping = abstractions.ErrorMappings{
+	"410": createAzureDeltaGoneErrorFromDiscriminatorValue,
+	"4XX": odataerrors.CreateODataErrorFromDiscriminatorValue,
+	"5XX": odataerrors.CreateODataErrorFromDiscriminatorValue,
+}
+
+// azureDelta holds the state of incremental sync with delta queries.
+type azureDelta struct {
+	fullSyncInterval time.Duration
+	clock            clock.PassiveClock
+	// store is nil if state is kept in memory only.
+	store azureDeltaStateStore
+
+	state  azureDeltaState
+	loaded bool
+}
+
+// azureDeltaState is persisted between sync cycles, so incremental sync continues after restart.
+type azureDeltaState struct {
+	Users  *azureDeltaSnapshot `json:"users,omitempty"`
+	Groups *azureDeltaSnapshot `json:"groups,omitempty"`
+}
+
+// azureDeltaSnapshot is a cache of all users or groups of the tenant, which is updated by delta queries.
+type azureDeltaSnapshot struct {
+	// DeltaLink is a link to get changes since the snapshot was updated.
+	DeltaLink string `json:"delta_link"`
+	// Select is a list of fields snapshot was fetched with, its change requires full sync.
+	Select       []string                     `json:"select"`
+	LastFullSync time.Time                    `json:"last_full_sync"`
+	Objects      map[string]*azureDeltaObject `json:"objects"`
+}
+
+// azureDeltaObject is a user or a group with properties as they are returned by MS Graph.
+type azureDeltaObject struct {
+	Properties map[string]any `json:"properties"`
+	// Members are @odata.type of direct group members by their ids.
+	Members map[string]string `json:"members,omitempty"`
+}
+
+// azureDeltaPage is a page of delta function response, the last page has delta link instead of next link.
+type azureDeltaPage struct {
+	Value     []map[string]any `json:"value"`
+	NextLink  string           `json:"@odata.nextLink"`
+	DeltaLink string           `json:"@odata.deltaLink"`
+}
+
+// azureDeltaRequestFactory creates delta request for the link or the initial request if link is empty.
+type azureDeltaRequestFactory func(ctx context.Context, link string) (*abstractions.RequestInformation, error)
+
+func newAzureDelta(cfg *AzureDeltaConfig) (*azureDelta, error) {
+	if cfg.StateFile != "" && cfg.StateYtsaurusPath != "" {
+		return nil, errors.New("only one of delta state_file and state_ytsaurus_path should be specified")
+	}
+	if cfg.FullSyncInterval == 0 {
+		cfg.FullSyncInterval = defaultAzureDeltaFullSyncInterval
+	}
+	delta := &azureDelta{
+		fullSyncInterval: cfg.FullSyncInterval,
+		clock:            clock.RealClock{},
+	}
+	if cfg.StateFile != "" {
+		delta.store = &azureDeltaFileStore{path: cfg.StateFile}
+	}
+	return delta, nil
+}
+
+func (a *AzureReal) getUsersFromDelta(ctx context.Context, filter string) ([]models.Userable, error) {
+	fieldsToSelect := a.userFieldsToSelect
+	err := a.syncDelta(ctx, "users", &a.delta.state.Users, fieldsToSelect,
+		func(ctx context.Context, link string) (*abstractions.RequestInformation, error) {
+			if link != "" {
+				return msgraphusers.NewDeltaRequestBuilder(link, a.graphClient.GetAdapter()).ToGetRequestInformation(ctx, nil)
+			}
+			// https://learn.microsoft.com/en-us/graph/api/user-delta
+			return a.graphClient.Users().Delta().ToGetRequestInformation(ctx, &msgraphusers.DeltaRequestBuilderGetRequestConfiguration{
+				QueryParameters: &msgraphusers.DeltaRequestBuilderGetQueryParameters{
+					Select: fieldsToSelect,
+				},
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := a.delta.state.Users
+	ids, err := a.getDeltaFilteredIDs(snapshot, filter, func() ([]models.DirectoryObjectable, error) {
+		users, err := a.listUsers(ctx, []string{"id"}, filter)
+		return toDirectoryObjects(users), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var users []models.Userable
+	for _, id := range ids {
+		user, err := snapshot.Objects[id].parse(models.CreateUserFromDiscriminatorValue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse cached user %s", id)
+		}
+		users = append(users, user.(models.Userable))
+	}
+	return users, nil
+}
+
+func (a *AzureReal) getGroupsFromDelta(ctx context.Context, filter string) ([]models.Groupable, error) {
+	fieldsToSelect := append(slices.Clone(defaultGroupFieldsToSelect), "members")
+	err := a.syncDelta(ctx, "groups", &a.delta.state.Groups, fieldsToSelect,
+		func(ctx context.Context, link string) (*abstractions.RequestInformation, error) {
+			if link != "" {
+				return msgraphgroups.NewDeltaRequestBuilder(link, a.graphClient.GetAdapter()).ToGetRequestInformation(ctx, nil)
+			}
+			// https://learn.microsoft.com/en-us/graph/api/group-delta
+			return a.graphClient.Groups().Delta().ToGetRequestInformation(ctx, &msgraphgroups.DeltaRequestBuilderGetRequestConfiguration{
+				QueryParameters: &msgraphgroups.DeltaRequestBuilderGetQueryParameters{
+					Select: fieldsToSelect,
+				},
+			})
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := a.delta.state.Groups
+	ids, err := a.getDeltaFilteredIDs(snapshot, filter, func() ([]models.DirectoryObjectable, error) {
+		groups, err := a.listGroups(ctx, []string{"id"}, filter, false)
+		return toDirectoryObjects(groups), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []models.Groupable
+	for _, id := range ids {
+		object := snapshot.Objects[id]
+		parsed, err := object.parse(models.CreateGroupFromDiscriminatorValue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse cached group %s", id)
+		}
+		group := parsed.(models.Groupable)
+		var members []models.DirectoryObjectable
+		for _, memberID := range sortedKeys(object.Members) {
+			members = append(members, newAzureDirectoryObject(memberID, object.Members[memberID]))
+		}
+		group.SetMembers(members)
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// getDeltaFilteredIDs returns ids of cached objects matching the filter.
+// Delta queries don't support filters, so ids of matching objects are listed separately, which is cheap.
+func (a *AzureReal) getDeltaFilteredIDs(
+	snapshot *azureDeltaSnapshot,
+	filter string,
+	list func() ([]models.DirectoryObjectable, error),
+) ([]string, error) {
+	if filter == "" {
+		return sortedKeys(snapshot.Objects), nil
+	}
+	objects, err := list()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, object := range objects {
+		id := handleNil(object.GetId())
+		if _, ok := snapshot.Objects[id]; !ok {
+			// Object was created after the delta query, it will be fetched with the next one.
+			a.logger.Debugw("Skipping object missing in delta snapshot", "id", id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// syncDelta applies changes since the previous sync to the snapshot or fetches it from scratch
+// if full sync is required, the updated state is persisted.
+func (a *AzureReal) syncDelta(
+	ctx context.Context,
+	name string,
+	snapshot **azureDeltaSnapshot,
+	fieldsToSelect []string,
+	newRequest azureDeltaRequestFactory,
+) error {
+	if !a.delta.loaded {
+		a.loadDeltaState()
+	}
+
+	now := a.delta.clock.Now()
+	current := *snapshot
+	fullSyncRequired := current == nil || current.DeltaLink == "" || !slices.Equal(current.Select, fieldsToSelect) ||
+		now.Sub(current.LastFullSync) >= a.delta.fullSyncInterval
+	updated, err := a.fetchDelta(ctx, name, current, fieldsToSelect, fullSyncRequired, newRequest)
+	if err != nil {
+		return err
+	}
+	*snapshot = updated
+
+	if a.delta.store != nil {
+		if err = a.delta.store.save(&a.delta.state); err != nil {
+			// State in memory is up to date, so sync goes on and saving is retried next time.
+			a.logger.Errorw("Failed to save Azure delta state", "error", err)
+		}
+	}
+	return nil
+}
+
+func (a *AzureReal) fetchDelta(
+	ctx context.Context,
+	name string,
+	snapshot *azureDeltaSnapshot,
+	fieldsToSelect []string,
+	fullSync bool,
+	newRequest azureDeltaRequestFactory,
+) (*azureDeltaSnapshot, error) {
+	link := ""
+	if fullSync {
+		a.logger.Infow("Starting full Azure delta sync", "resource", name)
+		snapshot = &azureDeltaSnapshot{
+			Select:       fieldsToSelect,
+			LastFullSync: a.delta.clock.Now(),
+			Objects:      make(map[string]*azureDeltaObject),
+		}
+	} else {
+		link = snapshot.DeltaLink
+	}
+
+	changed := 0
+	for {
+		page, err := a.getDeltaPage(ctx, newRequest, link)
+		if err != nil {
+			if !fullSync && isAzureDeltaGone(err) {
+				a.logger.Warnw("Azure delta link has expired, starting full sync", "resource", name)
+				return a.fetchDelta(ctx, name, nil, fieldsToSelect, true, newRequest)
+			}
+			return nil, errors.Wrapf(err, "failed to get %s delta", name)
+		}
+		// Incremental changes are applied in place: if sync fails, they are applied once again from
+		// the same delta link the next time, which gives the same result.
+		snapshot.apply(page.Value)
+		changed += len(page.Value)
+		if page.NextLink != "" {
+			link = page.NextLink
+			continue
+		}
+		if page.DeltaLink == "" {
+			return nil, errors.Errorf("%s delta response has neither next nor delta link", name)
+		}
+		snapshot.DeltaLink = page.DeltaLink
+		break
+	}
+	a.logger.Infow("Finished Azure delta sync",
+		"resource", name,
+		"full", fullSync,
+		"changed", changed,
+		"total", len(snapshot.Objects),
+	)
+	return snapshot, nil
+}
+
+func (a *AzureReal) getDeltaPage(ctx context.Context, newRequest azureDeltaRequestFactory, link string) (*azureDeltaPage, error) {
+	requestInfo, err := newRequest(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	// Raw response is used, since changes are represented by annotations (like @removed and members@delta),
+	// which are not part of the SDK models.
+	content, err := a.graphClient.GetAdapter().SendPrimitive(ctx, requestInfo, "[]byte", azureDeltaErrorMapping)
+	if err != nil {
+		return nil, err
+	}
+	var page azureDeltaPage
+	if content != nil {
+		if err = json.Unmarshal(content.([]byte), &page); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal delta response")
+		}
+	}
+	return &page, nil
+}
+
+func (a *AzureReal) loadDeltaState() {
+	a.delta.loaded = true
+	if a.delta.store == nil {
+		return
+	}
+	state, err := a.delta.store.load()
+	if err != nil {
+		// Broken state shouldn't stop sync, full sync rebuilds it.
+		a.logger.Errorw("Failed to load Azure delta state, starting from scratch", "error", err)
+		return
+	}
+	if state != nil {
+		a.delta.state = *state
+	}
+}
+
+// apply updates the snapshot with changed objects from delta response.
+// Changed objects contain only the changed properties, removed objects have @removed annotation.
+func (s *azureDeltaSnapshot) apply(changes []map[string]any) {
+	for _, change := range changes {
+		id, _ := change["id"].(string)
+		if id == "" {
+			continue
+		}
+		if _, removed := change["@removed"]; removed {
+			delete(s.Objects, id)
+			continue
+		}
+		object, ok := s.Objects[id]
+		if !ok {
+			object = &azureDeltaObject{Properties: make(map[string]any)}
+			s.Objects[id] = object
+		}
+		for key, value := range change {
+			switch {
+			case key == "members@delta":
+				// Members of a large group are returned across several pages, so they are always merged.
+				object.applyMembers(value)
+			case strings.HasPrefix(key, "@"):
+				// Skip annotations like @odata.type.
+			default:
+				object.Properties[key] = value
+			}
+		}
+	}
+}
+
+func (o *azureDeltaObject) applyMembers(value any) {
+	members, _ := value.([]any)
+	if o.Members == nil {
+		o.Members = make(map[string]string)
+	}
+	for _, member := range members {
+		member, _ := member.(map[string]any)
+		id, _ := member["id"].(string)
+		if id == "" {
+			continue
+		}
+		if _, removed := member["@removed"]; removed {
+			delete(o.Members, id)
+			continue
+		}
+		odataType, _ := member["@odata.type"].(string)
+		o.Members[id] = odataType
+	}
+}
+
+// parse converts cached properties to the SDK model, the same way as MS Graph responses are parsed.
+func (o *azureDeltaObject) parse(factory serialization.ParsableFactory) (serialization.Parsable, error) {
+	content, err := json.Marshal(o.Properties)
+	if err != nil {
+		return nil, err
+	}
+	node, err := jsonserialization.NewJsonParseNode(content)
+	if err != nil {
+		return nil, err
+	}
+	return node.GetObjectValue(factory)
+}
+
+// transitiveUsers returns users and service principals which are members of the group directly or via nested groups.
+func (s *azureDeltaSnapshot) transitiveUsers(groupID string) []models.DirectoryObjectable {
+	visited := map[string]bool{groupID: true}
+	queue := []string{groupID}
+	var users []models.DirectoryObjectable
+	for len(queue) > 0 {
+		group, ok := s.Objects[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for _, memberID := range sortedKeys(group.Members) {
+			if visited[memberID] {
+				continue
+			}
+			visited[memberID] = true
+			switch group.Members[memberID] {
+			case azureUserODataType, azureServicePrincipalODataType:
+				users = append(users, newAzureDirectoryObject(memberID, group.Members[memberID]))
+			case azureGroupODataType:
+				queue = append(queue, memberID)
+			}
+		}
+	}
+	return users
+}
+
+func newAzureDirectoryObject(id, odataType string) models.DirectoryObjectable {
+	var object models.DirectoryObjectable
+	switch odataType {
+	case azureUserODataType:
+		object = models.NewUser()
+	case azureGroupODataType:
+		object = models.NewGroup()
+	case azureServicePrincipalODataType:
+		object = models.NewServicePrincipal()
+	default:
+		object = models.NewDirectoryObject()
+		object.SetOdataType(&odataType)
+	}
+	object.SetId(&id)
+	return object
+}
+
+func toDirectoryObjects[T models.DirectoryObjectable](objects []T) []models.DirectoryObjectable {
+	result := make([]models.DirectoryObjectable, 0, len(objects))
+	for _, object := range objects {
+		result = append(result, object)
+	}
+	return result
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// azureDeltaGoneError is returned on 410 Gone, when delta link has expired and full resync is required.
+type azureDeltaGoneError struct {
+	*odataerrors.ODataError
+}
+
+func createAzureDeltaGoneErrorFromDiscriminatorValue(serialization.ParseNode) (serialization.Parsable, error) {
+	return &azureDeltaGoneError{ODataError: odataerrors.NewODataError()}, nil
+}
+
+func isAzureDeltaGone(err error) bool {
+	var goneErr *azureDeltaGoneError
+	if errors.As(err, &goneErr) {
+		return true
+	}
+	// Response without body is not parsed into the mapped error.
+	var apiErr *abstractions.ApiError
+	return errors.As(err, &apiErr) && apiErr.ResponseStatusCode == http.StatusGone
+}
+
+// azureDeltaStateStore persists delta state between sync cycles.
+type azureDeltaStateStore interface {
+	// load returns nil if nothing is stored yet.
+	load() (*azureDeltaState, error)
+	save(state *azureDeltaState) error
+}
+
+type azureDeltaFileStore struct {
+	path string
+}
+
+func (s *azureDeltaFileStore) load() (*azureDeltaState, error) {
+	content, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read delta state file %s", s.path)
+	}
+	var state azureDeltaState
+	if err = json.Unmarshal(content, &state); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal delta state file %s", s.path)
+	}
+	return &state, nil
+}
+
+func (s *azureDeltaFileStore) save(state *azureDeltaState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal delta state")
+	}
+	// File is replaced atomically, so state is not broken by a crash in the middle of writing.
+	tmpPath := s.path + ".tmp"
+	if err = os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return errors.Wrapf(err, "failed to write delta state file %s", tmpPath)
+	}
+	return errors.Wrapf(os.Rename(tmpPath, s.path), "failed to replace delta state file %s", s.path)
+}
+
+// azureDeltaYtsaurusStore keeps delta state in a file node of YTsaurus cluster.
+type azureDeltaYtsaurusStore struct {
+	client  yt.Client
+	path    ypath.Path
+	timeout time.Duration
+}
+
+func newAzureDeltaYtsaurusStore(path string, cfg *YtsaurusConfig) (*azureDeltaYtsaurusStore, error) {
+	client, err := newYtsaurusClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultYtsaurusTimeout
+	}
+	return &azureDeltaYtsaurusStore{
+		client:  client,
+		path:    ypath.Path(path),
+		timeout: timeout,
+	}, nil
+}
+
+func (s *azureDeltaYtsaurusStore) load() (*azureDeltaState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	exists, err := s.client.NodeExists(ctx, s.path, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to check delta state node %s", s.path)
+	}
+	if !exists {
+		return nil, nil
+	}
+	r, err := s.client.ReadFile(ctx, s.path, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read delta state node %s", s.path)
+	}
+	defer r.Close()
+	var state azureDeltaState
+	if err = json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal delta state node %s", s.path)
+	}
+	return &state, nil
+}
+
+func (s *azureDeltaYtsaurusStore) save(state *azureDeltaState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.client.CreateNode(ctx, s.path, yt.NodeFile, &yt.CreateNodeOptions{
+		Recursive:      true,
+		IgnoreExisting: true,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to create delta state node %s", s.path)
+	}
+	w, err := s.client.WriteFile(ctx, s.path, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write delta state node %s", s.path)
+	}
+	if err = json.NewEncoder(w).Encode(state); err != nil {
+		_ = w.Close()
+		return errors.Wrapf(err, "failed to write delta state node %s", s.path)
+	}
+	return errors.Wrapf(w.Close(), "failed to write delta state node %s", s.path)
+}
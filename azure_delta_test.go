@@ -0,0 +1,120 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	testclock "k8s.io/utils/clock/testing"
+)
+
+func getAzureDisplayNames(t *testing.T, azure *AzureReal) map[string]string {
+	users, err := azure.GetUsers()
+	require.NoError(t, err)
+	displayNames := make(map[string]string)
+	for _, user := range users {
+		displayNames[user.GetID()] = user.(AzureUser).DisplayName
+	}
+	return displayNames
+}
+
+func TestAzureDeltaSync(t *testing.T) {
+	server := newFakeGraphServer(t)
+	server.pageSize = 2
+	addUser := func(id, displayName string, isMember bool) {
+		server.addObject(fakeGraphUserType, map[string]any{
+			"id":                id,
+			"userPrincipalName": id + "@acme.com",
+			"displayName":       displayName,
+			"userType":          map[bool]string{true: "Member", false: "Guest"}[isMember],
+		})
+	}
+	server.filters["userType eq 'Member'"] = func(object map[string]any) bool {
+		return object["userType"] == "Member"
+	}
+	addUser("alice", "Alice", true)
+	addUser("bob", "Bob", true)
+	addUser("guest", "Guest", false)
+	server.addGroup("group-devs", "devs", "alice", "bob")
+
+	cfg := &AzureConfig{
+		UsersFilter: "userType eq 'Member'",
+		Delta: &AzureDeltaConfig{
+			StateFile: filepath.Join(t.TempDir(), "delta.json"),
+		},
+	}
+	passiveClock := testclock.NewFakePassiveClock(initialTestTime)
+	newAzure := func() *AzureReal {
+		azure := newFakeGraphAzure(t, server, cfg)
+		azure.delta.clock = passiveClock
+		return azure
+	}
+	azure := newAzure()
+
+	require.Equal(t, map[string]string{"alice": "Alice", "bob": "Bob"}, getAzureDisplayNames(t, azure))
+	require.Equal(t, map[string]StringSet{"devs": NewStringSetFromItems("alice", "bob")}, getAzureGroupMembers(t, azure))
+	require.Equal(t, []string{"", ""}, server.getDeltaTokens())
+
+	addUser("alice", "Alicia", true)
+	addUser("carol", "Carol", true)
+	server.removeObject("bob")
+	server.setMembers("group-devs", "alice", "carol")
+	require.Equal(t, map[string]string{"alice": "Alicia", "carol": "Carol"}, getAzureDisplayNames(t, azure))
+	require.Equal(t, map[string]StringSet{"devs": NewStringSetFromItems("alice", "carol")}, getAzureGroupMembers(t, azure))
+	deltaTokens := server.getDeltaTokens()
+	require.Len(t, deltaTokens, 4)
+	require.NotEmpty(t, deltaTokens[2])
+	require.NotEmpty(t, deltaTokens[3])
+
+	// State is persisted, so incremental sync continues after restart.
+	azure = newAzure()
+	require.Equal(t, map[string]string{"alice": "Alicia", "carol": "Carol"}, getAzureDisplayNames(t, azure))
+	require.Equal(t, map[string]StringSet{"devs": NewStringSetFromItems("alice", "carol")}, getAzureGroupMembers(t, azure))
+	deltaTokens = server.getDeltaTokens()
+	require.Len(t, deltaTokens, 6)
+	require.NotEmpty(t, deltaTokens[4])
+	require.NotEmpty(t, deltaTokens[5])
+
+	// Expired delta link leads to full sync.
+	server.deltaGone = true
+	addUser("dave", "Dave", true)
+	require.Equal(t, map[string]string{"alice": "Alicia", "carol": "Carol", "dave": "Dave"}, getAzureDisplayNames(t, azure))
+	deltaTokens = server.getDeltaTokens()
+	require.Len(t, deltaTokens, 8)
+	require.NotEmpty(t, deltaTokens[6])
+	require.Empty(t, deltaTokens[7])
+	server.deltaGone = false
+
+	// Full sync is forced periodically.
+	passiveClock.SetTime(initialTestTime.Add(25 * time.Hour))
+	require.Equal(t, map[string]string{"alice": "Alicia", "carol": "Carol", "dave": "Dave"}, getAzureDisplayNames(t, azure))
+	deltaTokens = server.getDeltaTokens()
+	require.Len(t, deltaTokens, 9)
+	require.Empty(t, deltaTokens[8])
+}
+
+func TestAzureDeltaTransitiveMembers(t *testing.T) {
+	server := newFakeGraphServer(t)
+	server.addUser("alice", "alice@acme.com")
+	server.addUser("bob", "bob@acme.com")
+	server.addGroup("group-devs", "devs", "alice")
+	server.addGroup("group-all", "all", "bob", "group-devs", "group-cycle")
+	server.addGroup("group-cycle", "cycle", "group-all")
+
+	azure := newFakeGraphAzure(t, server, &AzureConfig{TransitiveMembers: true, Delta: &AzureDeltaConfig{}})
+	expected := map[string]StringSet{
+		"devs":  NewStringSetFromItems("alice"),
+		"all":   NewStringSetFromItems("alice", "bob"),
+		"cycle": NewStringSetFromItems("alice", "bob"),
+	}
+	require.Equal(t, expected, getAzureGroupMembers(t, azure))
+
+	// Nested membership change is applied to the parent groups without fetching members.
+	server.setMembers("group-devs")
+	expected["devs"] = NewStringSet()
+	expected["all"] = NewStringSetFromItems("bob")
+	expected["cycle"] = NewStringSetFromItems("bob")
+	require.Equal(t, expected, getAzureGroupMembers(t, azure))
+	require.Equal(t, []string{"/v1.0/groups/delta()", "/v1.0/groups/delta()"}, server.getRequests())
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/microsoft/kiota-abstractions-go/authentication"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	fakeGraphUserType  = "#microsoft.graph.user"
+	fakeGraphGroupType = "#microsoft.graph.group"
+
+	fakeGraphServicePrincipalType = "#microsoft.graph.servicePrincipal"
+)
+
+// fakeGraphServer is a minimal in-process MS Graph stand-in, which serves users, groups and their members.
+// Collections are paged by pageSize with @odata.nextLink as Graph does.
+type fakeGraphServer struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	pageSize int
+	// objects are Graph JSON objects by id, groups have their member ids in members.
+	objects  map[string]map[string]any
+	order    []string
+	members  map[string][]string
+	owners   map[string][]string
+	requests []string
+	// appRoleAssignments are assignments to app roles by resource service principal ids.
+	appRoleAssignments map[string][]map[string]any
+	// filters emulate $filter values: objects matching the filter.
+	filters map[string]func(object map[string]any) bool
+
+	// version is incremented on every change, delta tokens are versions.
+	version int
+	// changes are versions of the last object changes by id, removed objects keep their type in removedTypes.
+	changes      map[string]int
+	removedTypes map[string]string
+	// memberChanges are versions of the last membership changes by group and member ids.
+	memberChanges map[string]map[string]int
+	// deltaTokens are tokens of delta rounds, empty for initial ones.
+	deltaTokens []string
+	// deltaGone makes delta requests with tokens fail with 410 Gone.
+	deltaGone bool
+	// faults are applied to the next requests one by one instead of serving them, they emulate throttling.
+	faults []fakeGraphFault
+	// responseDelay delays every response, so concurrent requests overlap.
+	responseDelay time.Duration
+	// inFlight is the number of requests being handled, maxInFlight is its maximum.
+	inFlight    int
+	maxInFlight int
+}
+
+// fakeGraphFault is either an error response with optional Retry-After header or a response delayed by delay.
+type fakeGraphFault struct {
+	status     int
+	retryAfter string
+	delay      time.Duration
+}
+
+func newFakeGraphServer(t *testing.T) *fakeGraphServer {
+	s := &fakeGraphServer{
+		pageSize:           100,
+		objects:            make(map[string]map[string]any),
+		members:            make(map[string][]string),
+		owners:             make(map[string][]string),
+		appRoleAssignments: make(map[string][]map[string]any),
+		filters:            make(map[string]func(object map[string]any) bool),
+		changes:            make(map[string]int),
+		removedTypes:       make(map[string]string),
+		memberChanges:      make(map[string]map[string]int),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// newFakeGraphAzure creates AzureReal which talks to the stand-in without authentication.
+func newFakeGraphAzure(t *testing.T, s *fakeGraphServer, cfg *AzureConfig) *AzureReal {
+	graphClient, err := newAzureGraphClient(&authentication.AnonymousAuthenticationProvider{}, cfg, getDevelopmentLogger())
+	require.NoError(t, err)
+	graphClient.GetAdapter().SetBaseUrl(s.server.URL + "/v1.0")
+	azure, err := newAzureRealWithClient(cfg, getDevelopmentLogger(), graphClient)
+	require.NoError(t, err)
+	return azure
+}
+
+func (s *fakeGraphServer) addObject(odataType string, object map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object["@odata.type"] = odataType
+	id := object["id"].(string)
+	if _, ok := s.changes[id]; !ok {
+		s.order = append(s.order, id)
+	}
+	s.objects[id] = object
+	delete(s.removedTypes, id)
+	s.version++
+	s.changes[id] = s.version
+}
+
+func (s *fakeGraphServer) removeObject(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removedTypes[id] = s.objects[id]["@odata.type"].(string)
+	delete(s.objects, id)
+	s.version++
+	s.changes[id] = s.version
+}
+
+func (s *fakeGraphServer) addUser(id, principalName string) {
+	s.addObject(fakeGraphUserType, map[string]any{
+		"id":                id,
+		"userPrincipalName": principalName,
+		"accountEnabled":    true,
+	})
+}
+
+func (s *fakeGraphServer) addServicePrincipal(id, displayName string) {
+	s.addObject(fakeGraphServicePrincipalType, map[string]any{
+		"id":          id,
+		"appId":       "app-" + id,
+		"displayName": displayName,
+	})
+}
+
+func (s *fakeGraphServer) addGroup(id, displayName string, memberIDs ...string) {
+	s.addObject(fakeGraphGroupType, map[string]any{"id": id, "displayName": displayName})
+	s.setMembers(id, memberIDs...)
+}
+
+// setMembers replaces group members without changing the group itself.
+func (s *fakeGraphServer) setMembers(groupID string, memberIDs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	if s.memberChanges[groupID] == nil {
+		s.memberChanges[groupID] = make(map[string]int)
+	}
+	for _, id := range append(append([]string(nil), s.members[groupID]...), memberIDs...) {
+		s.memberChanges[groupID][id] = s.version
+	}
+	s.members[groupID] = memberIDs
+}
+
+func (s *fakeGraphServer) setOwners(groupID string, ownerIDs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owners[groupID] = ownerIDs
+}
+
+func (s *fakeGraphServer) addAppRole(servicePrincipalID, roleID, value string, enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	servicePrincipal := s.objects[servicePrincipalID]
+	roles, _ := servicePrincipal["appRoles"].([]map[string]any)
+	servicePrincipal["appRoles"] = append(roles, map[string]any{
+		"id":                 roleID,
+		"value":              value,
+		"displayName":        "Role " + value,
+		"isEnabled":          enabled,
+		"allowedMemberTypes": []string{"User", "Application"},
+	})
+}
+
+// assignAppRole assigns user, group or service principal to the role of the application.
+func (s *fakeGraphServer) assignAppRole(servicePrincipalID, roleID, principalID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	principalTypes := map[string]string{
+		fakeGraphUserType:             "User",
+		fakeGraphGroupType:            "Group",
+		fakeGraphServicePrincipalType: "ServicePrincipal",
+	}
+	s.appRoleAssignments[servicePrincipalID] = append(s.appRoleAssignments[servicePrincipalID], map[string]any{
+		"id":            principalID + "-" + roleID,
+		"appRoleId":     roleID,
+		"principalId":   principalID,
+		"principalType": principalTypes[s.objects[principalID]["@odata.type"].(string)],
+		"resourceId":    servicePrincipalID,
+	})
+}
+
+func (s *fakeGraphServer) getRequests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *fakeGraphServer) getDeltaTokens() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deltaTokens...)
+}
+
+func (s *fakeGraphServer) getMaxInFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxInFlight
+}
+
+func (s *fakeGraphServer) addFaults(faults ...fakeGraphFault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, faults...)
+}
+
+func (s *fakeGraphServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.Path)
+	var fault *fakeGraphFault
+	if len(s.faults) > 0 {
+		fault = &s.faults[0]
+		s.faults = s.faults[1:]
+	}
+	s.inFlight++
+	s.maxInFlight = max(s.maxInFlight, s.inFlight)
+	delay := s.responseDelay
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+
+	if delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	if fault != nil {
+		if fault.delay > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(fault.delay):
+			}
+		}
+		if fault.status != 0 {
+			if fault.retryAfter != "" {
+				w.Header().Set("Retry-After", fault.retryAfter)
+			}
+			s.writeError(w, fault.status, "Throttled", http.StatusText(fault.status))
+			return
+		}
+	}
+	s.serve(w, r)
+}
+
+func (s *fakeGraphServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1.0/"), "/")
+	filter := r.URL.Query().Get("$filter")
+	if _, ok := s.filters[filter]; filter != "" && !ok {
+		s.writeError(w, http.StatusBadRequest, "Request_UnsupportedQuery", "unknown filter "+filter)
+		return
+	}
+	var items []map[string]any
+	switch {
+	case len(parts) == 1 && parts[0] == "users":
+		items = s.selectFields(r, s.listByType(fakeGraphUserType, filter))
+	case len(parts) == 1 && parts[0] == "servicePrincipals":
+		items = s.listByType(fakeGraphServicePrincipalType, filter)
+	case len(parts) == 2 && parts[0] == "servicePrincipals":
+		object, ok := s.objects[parts[1]]
+		if !ok || object["@odata.type"] != fakeGraphServicePrincipalType {
+			s.writeError(w, http.StatusNotFound, "Request_ResourceNotFound", "unknown service principal "+parts[1])
+			return
+		}
+		s.writeJSON(w, http.StatusOK, object)
+		return
+	case len(parts) == 3 && parts[0] == "servicePrincipals" && parts[2] == "appRoleAssignedTo":
+		items = s.appRoleAssignments[parts[1]]
+	case len(parts) == 1 && parts[0] == "groups":
+		for _, group := range s.listByType(fakeGraphGroupType, filter) {
+			if strings.Contains(r.URL.Query().Get("$expand"), "members") {
+				// $expand returns at most 20 members.
+				group = copyFakeGraphObject(group)
+				group["members"] = s.selectID(s.directMembers(group["id"].(string), msgraphExpandLimit))
+			}
+			items = append(items, group)
+		}
+	case len(parts) == 3 && parts[0] == "groups" && parts[2] == "owners":
+		for _, id := range s.owners[parts[1]] {
+			items = append(items, s.objects[id])
+		}
+		items = s.selectID(items)
+	case len(parts) == 3 && parts[0] == "groups" && parts[2] == "members":
+		items = s.selectID(s.directMembers(parts[1], 0))
+	// SDK uses `graph` alias of `microsoft.graph` namespace in OData casts.
+	case len(parts) == 4 && parts[0] == "groups" && parts[2] == "transitiveMembers" &&
+		(strings.HasPrefix(parts[3], "graph.") || strings.HasPrefix(parts[3], "microsoft.graph.")):
+		if r.Header.Get("ConsistencyLevel") != "eventual" {
+			s.writeError(w, http.StatusBadRequest, "Request_UnsupportedQuery", "ConsistencyLevel header is required")
+			return
+		}
+		odataType := "#microsoft.graph." + parts[3][strings.LastIndex(parts[3], ".")+1:]
+		for _, member := range s.transitiveMembers(parts[1], make(map[string]bool)) {
+			if member["@odata.type"] == odataType {
+				items = append(items, member)
+			}
+		}
+		items = s.selectID(items)
+	// SDK calls delta function as `delta()`.
+	case len(parts) == 2 && (parts[1] == "delta" || parts[1] == "delta()") && (parts[0] == "users" || parts[0] == "groups"):
+		token := r.URL.Query().Get("$deltatoken")
+		if r.URL.Query().Get("$skiptoken") == "" {
+			s.deltaTokens = append(s.deltaTokens, token)
+		}
+		if token != "" && s.deltaGone {
+			s.writeError(w, http.StatusGone, "resyncRequired", "delta token has expired")
+			return
+		}
+		odataType := fakeGraphUserType
+		if parts[0] == "groups" {
+			odataType = fakeGraphGroupType
+		}
+		since := -1
+		if token != "" {
+			since, _ = strconv.Atoi(token)
+		}
+		items = s.delta(odataType, since)
+		if odataType == fakeGraphUserType {
+			items = s.selectFields(r, items)
+		}
+		s.writeDeltaPage(w, r, items)
+		return
+	default:
+		s.writeError(w, http.StatusNotFound, "Request_ResourceNotFound", "unknown resource "+r.URL.Path)
+		return
+	}
+	s.writePage(w, r, items)
+}
+
+func (s *fakeGraphServer) listByType(odataType string, filter string) []map[string]any {
+	var items []map[string]any
+	for _, id := range s.order {
+		object, ok := s.objects[id]
+		if !ok || object["@odata.type"] != odataType {
+			continue
+		}
+		if filter != "" && !s.filters[filter](object) {
+			continue
+		}
+		items = append(items, object)
+	}
+	return items
+}
+
+// delta returns objects changed after the since version, or all objects for the initial request (since < 0).
+// Groups have changed members in members@delta.
+func (s *fakeGraphServer) delta(odataType string, since int) []map[string]any {
+	var items []map[string]any
+	for _, id := range s.order {
+		object, ok := s.objects[id]
+		if !ok {
+			if since >= 0 && s.removedTypes[id] == odataType && s.changes[id] > since {
+				items = append(items, map[string]any{"id": id, "@removed": map[string]any{"reason": "deleted"}})
+			}
+			continue
+		}
+		if object["@odata.type"] != odataType {
+			continue
+		}
+		var memberChanges []map[string]any
+		if odataType == fakeGraphGroupType {
+			memberChanges = s.memberDelta(id, since)
+		}
+		if s.changes[id] <= since && len(memberChanges) == 0 {
+			continue
+		}
+		object = copyFakeGraphObject(object)
+		if memberChanges != nil {
+			object["members@delta"] = memberChanges
+		}
+		items = append(items, object)
+	}
+	return items
+}
+
+func (s *fakeGraphServer) memberDelta(groupID string, since int) []map[string]any {
+	current := make(map[string]bool)
+	for _, id := range s.members[groupID] {
+		current[id] = true
+	}
+	var ids []string
+	for id, version := range s.memberChanges[groupID] {
+		if version > since && (since >= 0 || current[id]) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	var changes []map[string]any
+	for _, id := range ids {
+		odataType, ok := s.removedTypes[id]
+		if object, exists := s.objects[id]; exists {
+			odataType, ok = object["@odata.type"].(string)
+		}
+		if !ok {
+			continue
+		}
+		change := map[string]any{"@odata.type": odataType, "id": id}
+		if !current[id] {
+			change["@removed"] = map[string]any{"reason": "deleted"}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func (s *fakeGraphServer) directMembers(groupID string, limit int) []map[string]any {
+	var members []map[string]any
+	for _, id := range s.members[groupID] {
+		if limit > 0 && len(members) == limit {
+			break
+		}
+		members = append(members, s.objects[id])
+	}
+	return members
+}
+
+func (s *fakeGraphServer) transitiveMembers(groupID string, visited map[string]bool) []map[string]any {
+	visited[groupID] = true
+	var members []map[string]any
+	for _, member := range s.directMembers(groupID, 0) {
+		id := member["id"].(string)
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		members = append(members, member)
+		if member["@odata.type"] == fakeGraphGroupType {
+			members = append(members, s.transitiveMembers(id, visited)...)
+		}
+	}
+	return members
+}
+
+// selectID emulates $select=id, @odata.type is returned anyway.
+func (s *fakeGraphServer) selectID(objects []map[string]any) []map[string]any {
+	var selected []map[string]any
+	for _, object := range objects {
+		selected = append(selected, map[string]any{"@odata.type": object["@odata.type"], "id": object["id"]})
+	}
+	return selected
+}
+
+// selectFields emulates $select, id and annotations are returned anyway.
+func (s *fakeGraphServer) selectFields(r *http.Request, objects []map[string]any) []map[string]any {
+	query := r.URL.Query().Get("$select")
+	if query == "" {
+		return objects
+	}
+	fields := strings.Split(query, ",")
+	var selected []map[string]any
+	for _, object := range objects {
+		selectedObject := make(map[string]any)
+		for key, value := range object {
+			if key == "id" || strings.Contains(key, "@") || slices.Contains(fields, key) {
+				selectedObject[key] = value
+			}
+		}
+		selected = append(selected, selectedObject)
+	}
+	return selected
+}
+
+func (s *fakeGraphServer) writePage(w http.ResponseWriter, r *http.Request, items []map[string]any) {
+	response, _ := s.page(r, items)
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+// writeDeltaPage writes a page with @odata.deltaLink on the last one.
+func (s *fakeGraphServer) writeDeltaPage(w http.ResponseWriter, r *http.Request, items []map[string]any) {
+	response, last := s.page(r, items)
+	if last {
+		query := r.URL.Query()
+		query.Del("$skiptoken")
+		query.Set("$deltatoken", strconv.Itoa(s.version))
+		link := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: query.Encode()}
+		response["@odata.deltaLink"] = link.String()
+	}
+	s.writeJSON(w, http.StatusOK, response)
+}
+
+func (s *fakeGraphServer) page(r *http.Request, items []map[string]any) (map[string]any, bool) {
+	offset := 0
+	if skipToken := r.URL.Query().Get("$skiptoken"); skipToken != "" {
+		offset, _ = strconv.Atoi(skipToken)
+	}
+	end := min(offset+s.pageSize, len(items))
+	response := map[string]any{"value": append([]map[string]any{}, items[offset:end]...)}
+	if end < len(items) {
+		query := r.URL.Query()
+		query.Set("$skiptoken", strconv.Itoa(end))
+		next := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: query.Encode()}
+		response["@odata.nextLink"] = next.String()
+		return response, false
+	}
+	return response, true
+}
+
+func (s *fakeGraphServer) writeError(w http.ResponseWriter, status int, code, message string) {
+	s.writeJSON(w, status, map[string]any{"error": map[string]any{"code": code, "message": message}})
+}
+
+func (s *fakeGraphServer) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func copyFakeGraphObject(object map[string]any) map[string]any {
+	copied := make(map[string]any, len(object))
+	for key, value := range object {
+		copied[key] = value
+	}
+	return copied
+}
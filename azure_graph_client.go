@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	absauth "github.com/microsoft/kiota-abstractions-go/authentication"
+	khttp "github.com/microsoft/kiota-http-go"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAzureMaxRetries        = 5
+	defaultAzureRetryInitialDelay = time.Second
+	defaultAzureRetryMaxDelay     = time.Minute
+
+	azureRetryAttemptHeader = "Retry-Attempt"
+)
+
+// newAzureGraphClient creates MS Graph client, which retries throttled and failed requests
+// and limits each attempt with the request timeout.
+func newAzureGraphClient(authProvider absauth.AuthenticationProvider, cfg *AzureConfig, logger appLoggerType) (*msgraphsdk.GraphServiceClient, error) {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultAzureTimeout
+	}
+	if cfg.Retry.MaxRetries == 0 {
+		cfg.Retry.MaxRetries = defaultAzureMaxRetries
+	}
+	if cfg.Retry.InitialDelay == 0 {
+		cfg.Retry.InitialDelay = defaultAzureRetryInitialDelay
+	}
+	if cfg.Retry.MaxDelay == 0 {
+		cfg.Retry.MaxDelay = defaultAzureRetryMaxDelay
+	}
+
+	clientOptions := msgraphsdk.GetDefaultClientOptions()
+	var middlewares []khttp.Middleware
+	for _, middleware := range msgraphcore.GetDefaultMiddlewaresWithOptions(&clientOptions) {
+		// SDK retry handler doesn't retry timeouts and network errors and its delays can't be shorter than a second,
+		// so it is replaced.
+		if _, isRetryHandler := middleware.(*khttp.RetryHandler); isRetryHandler {
+			middleware = &azureRetryHandler{
+				timeout:      cfg.Timeout,
+				maxRetries:   cfg.Retry.MaxRetries,
+				initialDelay: cfg.Retry.InitialDelay,
+				maxDelay:     cfg.Retry.MaxDelay,
+				logger:       logger,
+			}
+		}
+		middlewares = append(middlewares, middleware)
+	}
+	httpClient := msgraphcore.GetDefaultClient(&clientOptions, middlewares...)
+	// Requests are limited by the fetch deadline of their context, attempts are limited by the retry handler.
+	httpClient.Timeout = 0
+
+	adapter, err := msgraphsdk.NewGraphRequestAdapterWithParseNodeFactoryAndSerializationWriterFactoryAndHttpClient(
+		authProvider, nil, nil, httpClient,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ms graph request adapter")
+	}
+	return msgraphsdk.NewGraphServiceClient(adapter), nil
+}
+
+// azureRetryHandler retries requests on throttling (429), 503 and 504 responses, timeouts and network errors.
+// Delay is doubled after each retry, Retry-After response header is honoured if it is present.
+// https://learn.microsoft.com/en-us/graph/throttling
+type azureRetryHandler struct {
+	timeout      time.Duration
+	maxRetries   int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+
+	logger appLoggerType
+}
+
+func (h *azureRetryHandler) Intercept(pipeline khttp.Pipeline, middlewareIndex int, req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	delay := h.initialDelay
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			req.Header.Set(azureRetryAttemptHeader, strconv.Itoa(attempt))
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req.Body = body
+			}
+		}
+		response, err := h.attempt(pipeline, middlewareIndex, req)
+		if attempt >= h.maxRetries || !h.isRetriable(ctx, req, response, err) {
+			return response, err
+		}
+
+		wait := delay
+		args := []any{"url", req.URL.String(), "attempt", attempt + 1}
+		if err != nil {
+			args = append(args, "error", err)
+		} else {
+			args = append(args, "status", response.StatusCode)
+			if retryAfter, ok := parseRetryAfter(response.Header.Get("Retry-After")); ok {
+				wait = retryAfter
+			}
+			// Connection is reused only if the body is read till the end.
+			_, _ = io.Copy(io.Discard, response.Body)
+			_ = response.Body.Close()
+		}
+		h.logger.Warnw("Retrying MS Graph request", append(args, "delay", wait)...)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		delay = min(2*delay, h.maxDelay)
+	}
+}
+
+func (h *azureRetryHandler) attempt(pipeline khttp.Pipeline, middlewareIndex int, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	response, err := pipeline.Next(req.WithContext(ctx), middlewareIndex)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Body is read after the response is returned, so the attempt timeout covers reading it too.
+	response.Body = &cancelOnCloseReader{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+func (h *azureRetryHandler) isRetriable(ctx context.Context, req *http.Request, response *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		// Fetch deadline is exceeded.
+		return false
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// Body can't be sent once again.
+		return false
+	}
+	if err != nil {
+		// Attempt timeout or network error.
+		return true
+	}
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter parses Retry-After header value, which is either delay in seconds or HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+type cancelOnCloseReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnCloseReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
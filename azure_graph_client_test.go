@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newThrottledFakeGraphServer(t *testing.T) *fakeGraphServer {
+	server := newFakeGraphServer(t)
+	server.pageSize = 1
+	server.addUser("alice", "alice@acme.com")
+	server.addUser("bob", "bob@acme.com")
+	return server
+}
+
+func getAzureUserIDs(t *testing.T, azure *AzureReal) []string {
+	users, err := azure.GetUsers()
+	require.NoError(t, err)
+	var ids []string
+	for _, user := range users {
+		ids = append(ids, user.GetID())
+	}
+	return ids
+}
+
+func TestAzureRetryAfter(t *testing.T) {
+	server := newThrottledFakeGraphServer(t)
+	server.addFaults(
+		fakeGraphFault{status: http.StatusTooManyRequests, retryAfter: "0"},
+		fakeGraphFault{status: http.StatusServiceUnavailable, retryAfter: "0"},
+	)
+	// Retry-After overrides the delay, otherwise the test would time out.
+	azure := newFakeGraphAzure(t, server, &AzureConfig{
+		FetchTimeout: 10 * time.Second,
+		Retry:        AzureRetryConfig{InitialDelay: time.Hour},
+	})
+
+	require.Equal(t, []string{"alice", "bob"}, getAzureUserIDs(t, azure))
+	require.Len(t, server.getRequests(), 4)
+}
+
+func TestAzureRetryBackoff(t *testing.T) {
+	server := newThrottledFakeGraphServer(t)
+	server.addFaults(
+		fakeGraphFault{status: http.StatusServiceUnavailable},
+		fakeGraphFault{status: http.StatusGatewayTimeout},
+		fakeGraphFault{status: http.StatusTooManyRequests},
+	)
+	azure := newFakeGraphAzure(t, server, &AzureConfig{
+		Retry: AzureRetryConfig{InitialDelay: 20 * time.Millisecond, MaxDelay: 40 * time.Millisecond},
+	})
+
+	start := time.Now()
+	require.Equal(t, []string{"alice", "bob"}, getAzureUserIDs(t, azure))
+	// Delays are 20ms, 40ms and 40ms capped by max delay.
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	require.Len(t, server.getRequests(), 5)
+}
+
+func TestAzureRetryTimedOutRequest(t *testing.T) {
+	server := newThrottledFakeGraphServer(t)
+	// The next page request hangs, so pagination is limited by the request timeout too.
+	server.addFaults(fakeGraphFault{}, fakeGraphFault{delay: 10 * time.Second})
+	azure := newFakeGraphAzure(t, server, &AzureConfig{
+		Timeout: 100 * time.Millisecond,
+		Retry:   AzureRetryConfig{InitialDelay: time.Millisecond},
+	})
+
+	require.Equal(t, []string{"alice", "bob"}, getAzureUserIDs(t, azure))
+	require.Len(t, server.getRequests(), 3)
+}
+
+func TestAzureRetryErrors(t *testing.T) {
+	server := newThrottledFakeGraphServer(t)
+	azure := newFakeGraphAzure(t, server, &AzureConfig{
+		Retry: AzureRetryConfig{MaxRetries: 2, InitialDelay: time.Millisecond},
+	})
+
+	// Retries are exhausted.
+	server.addFaults(
+		fakeGraphFault{status: http.StatusTooManyRequests},
+		fakeGraphFault{status: http.StatusTooManyRequests},
+		fakeGraphFault{status: http.StatusTooManyRequests},
+	)
+	_, err := azure.GetUsers()
+	require.Error(t, err)
+	require.Len(t, server.getRequests(), 3)
+
+	// Other errors are not retried.
+	server.addFaults(fakeGraphFault{status: http.StatusForbidden})
+	_, err = azure.GetUsers()
+	require.Error(t, err)
+	require.Len(t, server.getRequests(), 4)
+}
+
+func TestAzureFetchTimeout(t *testing.T) {
+	server := newThrottledFakeGraphServer(t)
+	server.addFaults(fakeGraphFault{status: http.StatusTooManyRequests, retryAfter: "3600"})
+	azure := newFakeGraphAzure(t, server, &AzureConfig{FetchTimeout: 200 * time.Millisecond})
+
+	start := time.Now()
+	_, err := azure.GetUsers()
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Len(t, server.getRequests(), 1)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	delay, ok := parseRetryAfter("120")
+	require.True(t, ok)
+	require.Equal(t, 2*time.Minute, delay)
+
+	delay, ok = parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	require.True(t, ok)
+	require.InDelta(t, time.Hour, delay, float64(5*time.Second))
+
+	delay, ok = parseRetryAfter("Mon, 02 Jan 2006 15:04:05 GMT")
+	require.True(t, ok)
+	require.Zero(t, delay)
+
+	for _, value := range []string{"", "-1", "soon"} {
+		_, ok = parseRetryAfter(value)
+		require.False(t, ok, value)
+	}
+}
@@ -14,6 +14,13 @@ type AzureUser struct {
 	FirstName   string   `yson:"first_name"`
 	LastName    string   `yson:"last_name"`
 	DisplayName string   `yson:"display_name"`
+	// Username is a value of AzureConfig.UsernameField, if it is not configured PrincipalName is used as name.
+	Username string `yson:"username,omitempty"`
+	// Attributes are values of AzureConfig.UserFields by MS Graph property names, missing properties are omitted.
+	Attributes map[string]any `yson:"attributes,omitempty"`
+
+	// Disabled is true if `accountEnabled` is false, it is not stored in YTsaurus, it is reflected by the user ban.
+	Disabled bool `yson:"-"`
 }
 
 func NewAzureUser(attributes map[string]any) (*AzureUser, error) {
@@ -35,9 +42,16 @@ func (au AzureUser) GetID() ObjectID {
 }
 
 func (au AzureUser) GetName() string {
+	if au.Username != "" {
+		return au.Username
+	}
 	return au.PrincipalName
 }
 
+func (au AzureUser) IsDisabled() bool {
+	return au.Disabled
+}
+
 func (au AzureUser) GetRaw() (map[string]any, error) {
 	bytes, err := yson.Marshal(au)
 	if err != nil {
@@ -52,6 +66,62 @@ func (au AzureUser) GetRaw() (map[string]any, error) {
 	return raw, nil
 }
 
+const (
+	// azureServicePrincipalKind is a value of `kind` key in raw representation of service principals,
+	// which distinguishes them from users.
+	azureServicePrincipalKind = "service_principal"
+)
+
+// AzureServicePrincipal is an application or managed identity, which is synced as YTsaurus robot user.
+type AzureServicePrincipal struct {
+	AzureID     ObjectID `yson:"id"`
+	AppID       string   `yson:"app_id"`
+	DisplayName string   `yson:"display_name"`
+	// Username is DisplayName with AzureServicePrincipalsConfig.UsernamePrefix.
+	Username string `yson:"username"`
+}
+
+func NewAzureServicePrincipal(attributes map[string]any) (*AzureServicePrincipal, error) {
+	bytes, err := yson.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	var servicePrincipal AzureServicePrincipal
+	err = yson.Unmarshal(bytes, &servicePrincipal)
+	if err != nil {
+		return nil, err
+	}
+	return &servicePrincipal, nil
+}
+
+func (asp AzureServicePrincipal) GetID() ObjectID {
+	return asp.AzureID
+}
+
+func (asp AzureServicePrincipal) GetName() string {
+	return asp.Username
+}
+
+func (asp AzureServicePrincipal) IsDisabled() bool {
+	return false
+}
+
+func (asp AzureServicePrincipal) GetRaw() (map[string]any, error) {
+	bytes, err := yson.Marshal(asp)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]any)
+	err = yson.Unmarshal(bytes, &raw)
+	if err != nil {
+		return nil, err
+	}
+	raw["kind"] = azureServicePrincipalKind
+	return raw, nil
+}
+
 type AzureGroup struct {
 	AzureID     ObjectID `yson:"id"`
 	DisplayName string   `yson:"display_name"`
@@ -32,6 +32,25 @@ func TestAzureUser(t *testing.T) {
 	)
 }
 
+// TestAzureUserWithFields ensures that configured fields are restored from raw representation.
+func TestAzureUserWithFields(t *testing.T) {
+	user := AzureUser{
+		PrincipalName: "alice@acme.com",
+		AzureID:       "fake-az-id-alice",
+		Username:      "ALICE01",
+		Attributes:    map[string]any{"department": "R&D"},
+	}
+	rawUser, err := user.GetRaw()
+	require.NoError(t, err)
+	require.Equal(t, "ALICE01", rawUser["username"])
+	require.Equal(t, map[string]any{"department": "R&D"}, rawUser["attributes"])
+
+	restoredUser, err := NewAzureUser(rawUser)
+	require.NoError(t, err)
+	require.Equal(t, user, *restoredUser)
+	require.Equal(t, "ALICE01", restoredUser.GetName())
+}
+
 // TestAzureGroup ensures that raw representation has expected value.
 func TestAzureGroup(t *testing.T) {
 	rawGroup, err := AzureGroup{
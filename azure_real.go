@@ -2,26 +2,36 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
 	"regexp"
+	"slices"
+	"sync"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoft/kiota-abstractions-go/serialization"
+	kiotaauth "github.com/microsoft/kiota-authentication-azure-go"
+	jsonserialization "github.com/microsoft/kiota-serialization-json-go"
 	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
 	msgraphcore "github.com/microsoftgraph/msgraph-sdk-go-core"
 	msgraphgroups "github.com/microsoftgraph/msgraph-sdk-go/groups"
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	msgraphserviceprincipals "github.com/microsoftgraph/msgraph-sdk-go/serviceprincipals"
 	msgraphusers "github.com/microsoftgraph/msgraph-sdk-go/users"
 	"github.com/pkg/errors"
 )
 
 const (
-	scope                    = "https://graph.microsoft.com/.default"
-	msgraphExpandLimit       = 20
-	defaultAzureTimeout      = 3 * time.Second
-	defaultAzureSecretEnvVar = "AZURE_CLIENT_SECRET"
+	scope                     = "https://graph.microsoft.com/.default"
+	msgraphExpandLimit        = 20
+	defaultAzureTimeout       = 30 * time.Second
+	defaultAzureFetchTimeout  = 10 * time.Minute
+	defaultAzureConcurrency   = 8
+	defaultAzureSecretEnvVar  = "AZURE_CLIENT_SECRET"
+	defaultAzureUsernameField = "userPrincipalName"
+
+	defaultAzureServicePrincipalUsernamePrefix = "robot-"
 )
 
 var (
@@ -38,6 +48,11 @@ var (
 		"id",
 		"displayName",
 	}
+	defaultServicePrincipalFieldsToSelect = []string{
+		"id",
+		"appId",
+		"displayName",
+	}
 )
 
 type AzureReal struct {
@@ -47,60 +62,122 @@ type AzureReal struct {
 	groupsFilter                     string
 	groupsDisplayNameRegexPostFilter *regexp.Regexp
 	userGroupsFilter                 string
-
-	logger  appLoggerType
-	timeout time.Duration
+	transitiveMembers                bool
+	groupOwners                      bool
+	// usernameField is empty if principal name is used as username.
+	usernameField      string
+	userFields         []string
+	userFieldsToSelect []string
+	// concurrency limits the number of groups, whose members or owners are fetched at the same time.
+	concurrency int
+	// servicePrincipals is not nil if service principals are synced as users.
+	servicePrincipals *AzureServicePrincipalsConfig
+	// appRoles is not nil if app roles of the enterprise application are synced instead of groups.
+	appRoles *AzureAppRolesConfig
+	// delta is not nil if incremental sync with delta queries is enabled.
+	delta *azureDelta
+
+	logger appLoggerType
+	// fetchTimeout limits fetching of all users or groups, each request is limited by the graph client.
+	fetchTimeout time.Duration
 
 	debugAzureIDs []string
 }
 
+// azureAccountEnabledFilterRegex matches $filter conditions on accountEnabled property.
+var azureAccountEnabledFilterRegex = regexp.MustCompile(`(?i)\baccountEnabled\b`)
+
 func NewAzureReal(cfg *AzureConfig, logger appLoggerType) (*AzureReal, error) {
-	// https://github.com/microsoftgraph/msgraph-sdk-go#22-create-an-authenticationprovider-object
-	// https://learn.microsoft.com/en-us/graph/sdks/choose-authentication-providers
-	if cfg.ClientSecretEnvVar == "" {
-		cfg.ClientSecretEnvVar = defaultAzureSecretEnvVar
-	}
-	secret := os.Getenv(cfg.ClientSecretEnvVar)
-	if secret == "" {
-		return nil, errors.Errorf("Azure secret in %s env var shouldn't be empty", cfg.ClientSecretEnvVar)
-	}
-	cred, err := azidentity.NewClientSecretCredential(
-		cfg.Tenant,
-		cfg.ClientID,
-		secret,
-		nil,
-	)
+	cred, err := newAzureCredential(cfg, azureCredentialOptions{})
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create Azure secret credentials")
+		return nil, err
 	}
 
-	graphClient, err := msgraphsdk.NewGraphServiceClientWithCredentials(cred, []string{scope})
+	authProvider, err := kiotaauth.NewAzureIdentityAuthenticationProviderWithScopesAndValidHosts(
+		cred, []string{scope}, []string{"graph.microsoft.com"},
+	)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create ms graph client form secret credentials")
+		return nil, errors.Wrap(err, "failed to create ms graph authentication provider")
 	}
+	graphClient, err := newAzureGraphClient(authProvider, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	return newAzureRealWithClient(cfg, logger, graphClient)
+}
 
-	if cfg.Timeout == 0 {
-		cfg.Timeout = defaultAzureTimeout
+// newAzureRealWithClient is used in tests with the client of the local Graph stand-in.
+func newAzureRealWithClient(cfg *AzureConfig, logger appLoggerType, graphClient *msgraphsdk.GraphServiceClient) (*AzureReal, error) {
+	if cfg.FetchTimeout == 0 {
+		cfg.FetchTimeout = defaultAzureFetchTimeout
+	}
+	if azureAccountEnabledFilterRegex.MatchString(cfg.UsersFilter) {
+		// Disabled users are banned only if they are fetched, filtered out ones are removed as before.
+		logger.Warnw("users_filter filters on accountEnabled, so disabled users aren't fetched and are removed "+
+			"instead of being banned, drop the accountEnabled condition from the filter",
+			"users_filter", cfg.UsersFilter)
+	}
+	if cfg.Concurrency < 0 {
+		return nil, fmt.Errorf("concurrency should be positive, got %d", cfg.Concurrency)
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultAzureConcurrency
 	}
 	if cfg.GroupsDisplayNameSuffixPostFilter != "" {
 		return nil, fmt.Errorf("groups_display_name_suffix_post_filter is deprecated, use groups_display_name_regex_post_filter")
 	}
 	var postFilterRegex *regexp.Regexp
 	if cfg.GroupsDisplayNameRegexPostFilter != "" {
+		var err error
 		postFilterRegex, err = regexp.Compile(cfg.GroupsDisplayNameRegexPostFilter)
 		if err != nil {
 			return nil, fmt.Errorf("failed to compile groups_display_name_regex_post_filter re: %w", err)
 		}
 	}
+	usernameField := cfg.UsernameField
+	if usernameField == defaultAzureUsernameField {
+		usernameField = ""
+	}
+	userFieldsToSelect := slices.Clone(defaultUserFieldsToSelect)
+	for _, field := range append([]string{usernameField}, cfg.UserFields...) {
+		if field != "" && !slices.Contains(userFieldsToSelect, field) {
+			userFieldsToSelect = append(userFieldsToSelect, field)
+		}
+	}
+
+	if cfg.AppRoles != nil && cfg.AppRoles.ServicePrincipalID == "" {
+		return nil, fmt.Errorf("app_roles service_principal_id is required")
+	}
+	if cfg.ServicePrincipals != nil && cfg.ServicePrincipals.UsernamePrefix == "" {
+		cfg.ServicePrincipals.UsernamePrefix = defaultAzureServicePrincipalUsernamePrefix
+	}
+
+	var delta *azureDelta
+	if cfg.Delta != nil {
+		var err error
+		delta, err = newAzureDelta(cfg.Delta)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &AzureReal{
 		usersFilter:                      cfg.UsersFilter,
 		groupsFilter:                     cfg.GroupsFilter,
 		groupsDisplayNameRegexPostFilter: postFilterRegex,
 		userGroupsFilter:                 cfg.UserGroupsFilter,
+		transitiveMembers:                cfg.TransitiveMembers,
+		groupOwners:                      cfg.GroupOwners,
+		concurrency:                      cfg.Concurrency,
+		usernameField:                    usernameField,
+		userFields:                       cfg.UserFields,
+		userFieldsToSelect:               userFieldsToSelect,
+		servicePrincipals:                cfg.ServicePrincipals,
+		appRoles:                         cfg.AppRoles,
+		delta:                            delta,
 
 		graphClient:   graphClient,
 		logger:        logger,
-		timeout:       cfg.Timeout,
+		fetchTimeout:  cfg.FetchTimeout,
 		debugAzureIDs: cfg.DebugAzureIDs,
 	}, nil
 }
@@ -114,6 +191,9 @@ func handleNil[T any](s *T) T {
 }
 
 func (a *AzureReal) CreateUserFromRaw(raw map[string]any) (SourceUser, error) {
+	if raw["kind"] == azureServicePrincipalKind {
+		return NewAzureServicePrincipal(raw)
+	}
 	return NewAzureUser(raw)
 }
 
@@ -122,10 +202,10 @@ func (a *AzureReal) CreateGroupFromRaw(raw map[string]any) (SourceGroup, error)
 }
 
 func (a *AzureReal) GetUsers() ([]SourceUser, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), a.fetchTimeout)
 	defer cancel()
 
-	usersRaw, err := a.getUsersRaw(ctx, defaultUserFieldsToSelect, a.usersFilter)
+	usersRaw, err := a.getUsersRaw(ctx, a.userFieldsToSelect, a.usersFilter)
 	if err != nil {
 		return nil, err
 	}
@@ -139,6 +219,12 @@ func (a *AzureReal) GetUsers() ([]SourceUser, error) {
 		firstName := handleNil(user.GetGivenName())
 		lastName := handleNil(user.GetSurname())
 		displayName := handleNil(user.GetDisplayName())
+		// Users without the field are treated as enabled.
+		disabled := user.GetAccountEnabled() != nil && !*user.GetAccountEnabled()
+		username, attributes, err := a.getUserFields(user)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get fields of user %s", id)
+		}
 
 		a.maybePrintDebugLogs(
 			id,
@@ -147,11 +233,17 @@ func (a *AzureReal) GetUsers() ([]SourceUser, error) {
 			"firstName", firstName,
 			"lastName", lastName,
 			"displayName", displayName,
+			"disabled", disabled,
+			"username", username,
+			"attributes", attributes,
 		)
 
 		if principalName == "" {
 			a.logger.Debugw("Skipping user with empty principal name", "user", user)
 			usersSkipped++
+		} else if a.usernameField != "" && username == "" {
+			a.logger.Debugw("Skipping user with empty username field", "id", id, "field", a.usernameField)
+			usersSkipped++
 		} else {
 			users = append(users,
 				AzureUser{
@@ -161,19 +253,225 @@ func (a *AzureReal) GetUsers() ([]SourceUser, error) {
 					FirstName:     firstName,
 					LastName:      lastName,
 					DisplayName:   displayName,
+					Username:      username,
+					Attributes:    attributes,
+					Disabled:      disabled,
 				})
 		}
 	}
 
 	a.logger.Infow("Fetched users from Azure AD", "got", len(usersRaw), "skipped", usersSkipped)
+
+	if a.servicePrincipals != nil {
+		servicePrincipals, err := a.getServicePrincipals(ctx)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, servicePrincipals...)
+	}
 	return users, nil
 }
 
+func (a *AzureReal) getServicePrincipals(ctx context.Context) ([]SourceUser, error) {
+	servicePrincipalsRaw, err := a.listServicePrincipals(ctx, defaultServicePrincipalFieldsToSelect, a.servicePrincipals.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Display names of service principals aren't unique, e.g. several managed identities may have the same name.
+	displayNamesCount := make(map[string]int)
+	for _, servicePrincipal := range servicePrincipalsRaw {
+		displayNamesCount[handleNil(servicePrincipal.GetDisplayName())]++
+	}
+
+	servicePrincipalsSkipped := 0
+	var servicePrincipals []SourceUser
+	for _, servicePrincipal := range servicePrincipalsRaw {
+		id := handleNil(servicePrincipal.GetId())
+		appID := handleNil(servicePrincipal.GetAppId())
+		displayName := handleNil(servicePrincipal.GetDisplayName())
+
+		a.maybePrintDebugLogs(id, "appID", appID, "displayName", displayName)
+
+		if displayName == "" {
+			a.logger.Debugw("Skipping service principal with empty display name", "id", id)
+			servicePrincipalsSkipped++
+			continue
+		}
+		username := a.servicePrincipals.UsernamePrefix + displayName
+		if displayNamesCount[displayName] > 1 {
+			// App id is unique, so usernames of principals with the same display name don't collide.
+			a.logger.Warnw("Service principal display name isn't unique, app id is appended to username",
+				"id", id, "app_id", appID, "display_name", displayName)
+			username += "-" + appID
+		}
+		servicePrincipals = append(servicePrincipals,
+			AzureServicePrincipal{
+				AzureID:     id,
+				AppID:       appID,
+				DisplayName: displayName,
+				Username:    username,
+			})
+	}
+
+	a.logger.Infow("Fetched service principals from Azure AD",
+		"got", len(servicePrincipalsRaw),
+		"skipped", servicePrincipalsSkipped,
+	)
+	return servicePrincipals, nil
+}
+
+func (a *AzureReal) listServicePrincipals(ctx context.Context, fieldsToSelect []string, filter string) ([]models.ServicePrincipalable, error) {
+	// https://learn.microsoft.com/en-us/graph/api/serviceprincipal-list
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+	count := true
+	requestConfig := &msgraphserviceprincipals.ServicePrincipalsRequestBuilderGetRequestConfiguration{
+		Headers: headers,
+		QueryParameters: &msgraphserviceprincipals.ServicePrincipalsRequestBuilderGetQueryParameters{
+			Count:  &count,
+			Filter: &filter,
+			Select: fieldsToSelect,
+		},
+	}
+	result, err := a.graphClient.ServicePrincipals().Get(ctx, requestConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get service principals")
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.ServicePrincipalable](
+		result,
+		a.graphClient.GetAdapter(),
+		models.CreateServicePrincipalCollectionResponseFromDiscriminatorValue,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create service principals page iterator")
+	}
+
+	var rawServicePrincipals []models.ServicePrincipalable
+	err = pageIterator.Iterate(ctx, func(servicePrincipal models.ServicePrincipalable) bool {
+		rawServicePrincipals = append(rawServicePrincipals, servicePrincipal)
+		// Return true to continue the iteration.
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to iterate over Azure service principals")
+	}
+	return rawServicePrincipals, nil
+}
+
+// getUserFields returns value of the username field and values of the configured user fields.
+func (a *AzureReal) getUserFields(user models.Userable) (string, map[string]any, error) {
+	if a.usernameField == "" && len(a.userFields) == 0 {
+		return "", nil, nil
+	}
+	properties, err := getAzureObjectProperties(user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	username := ""
+	switch value := properties[a.usernameField].(type) {
+	case nil:
+	case string:
+		username = value
+	default:
+		return "", nil, errors.Errorf("username field %s has non-string value %v", a.usernameField, value)
+	}
+	var attributes map[string]any
+	for _, field := range a.userFields {
+		if value := properties[field]; value != nil {
+			if attributes == nil {
+				attributes = make(map[string]any)
+			}
+			attributes[field] = value
+		}
+	}
+	return username, attributes, nil
+}
+
+// getAzureObjectProperties returns properties of the object as they are in MS Graph JSON.
+// Unlike model getters, it works for any property including extension attributes, which are in additional data.
+func getAzureObjectProperties(object serialization.Parsable) (map[string]any, error) {
+	writer := jsonserialization.NewJsonSerializationWriter()
+	defer writer.Close()
+	if err := writer.WriteObjectValue("", object); err != nil {
+		return nil, errors.Wrap(err, "failed to serialize object")
+	}
+	content, err := writer.GetSerializedContent()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize object")
+	}
+	var properties map[string]any
+	if err = json.Unmarshal(content, &properties); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal object")
+	}
+	return properties, nil
+}
+
 func (a *AzureReal) GetGroupsWithMembers() ([]SourceGroupWithMembers, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), a.fetchTimeout)
 	defer cancel()
 
-	return a.getGroupsWithMembers(ctx, defaultGroupFieldsToSelect, a.groupsFilter)
+	if a.appRoles != nil {
+		return a.getAppRolesWithMembers(ctx)
+	}
+	groups, err := a.getGroupsWithMembers(ctx, defaultGroupFieldsToSelect, a.groupsFilter)
+	if err != nil {
+		return nil, err
+	}
+	if a.groupOwners {
+		err = runConcurrently(ctx, a.concurrency, len(groups), func(ctx context.Context, i int) error {
+			id := groups[i].SourceGroup.GetID()
+			owners, err := a.getGroupOwners(ctx, id)
+			if err != nil {
+				return errors.Wrapf(err, "failed to fetch owners of group %s", id)
+			}
+			groups[i].Managers = owners
+			a.maybePrintDebugLogs(id, "azure_owners_count", owners.Cardinality())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// getGroupOwners returns ids of group owners, which are users or service principals.
+func (a *AzureReal) getGroupOwners(ctx context.Context, groupID string) (StringSet, error) {
+	// https://learn.microsoft.com/en-us/graph/api/group-list-owners
+	configuration := &msgraphgroups.ItemOwnersRequestBuilderGetRequestConfiguration{
+		QueryParameters: &msgraphgroups.ItemOwnersRequestBuilderGetQueryParameters{
+			Select: []string{"id"},
+		},
+	}
+	result, err := a.graphClient.Groups().ByGroupId(groupID).Owners().Get(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.DirectoryObjectable](
+		result,
+		a.graphClient.GetAdapter(),
+		models.CreateDirectoryObjectCollectionResponseFromDiscriminatorValue,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create owners page iterator")
+	}
+
+	owners := NewStringSet()
+	err = pageIterator.Iterate(ctx, func(owner models.DirectoryObjectable) bool {
+		if id := owner.GetId(); id != nil {
+			owners.Add(*id)
+		}
+		// Return true to continue the iteration.
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to iterate over Azure group owners")
+	}
+	return owners, nil
 }
 
 func (a *AzureReal) getGroupsWithMembers(ctx context.Context, fieldsToSelect []string, filter string) ([]SourceGroupWithMembers, error) {
@@ -184,6 +482,9 @@ func (a *AzureReal) getGroupsWithMembers(ctx context.Context, fieldsToSelect []s
 
 	groupsSkipped := 0
 	var groups []SourceGroupWithMembers
+	var groupsMembers [][]models.DirectoryObjectable
+	// membersToFetch are indexes of groups, whose members are fetched separately.
+	var membersToFetch []int
 	for _, group := range groupsRaw {
 		displayName := handleNil(group.GetDisplayName())
 		id := handleNil(group.GetId())
@@ -200,34 +501,61 @@ func (a *AzureReal) getGroupsWithMembers(ctx context.Context, fieldsToSelect []s
 			continue
 		}
 
-		memberIDs := NewStringSet()
 		members := group.GetMembers()
-		if len(members) == msgraphExpandLimit {
-			// By default, $expand returns only 20 members, for those groups we collect all users by group id.
-			members, err = a.getGroupMembers(ctx, id)
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to fetch all members")
+		switch {
+		case a.delta != nil:
+			// Delta snapshot has all direct members of all groups, so effective membership is resolved locally.
+			if a.transitiveMembers && hasNestedGroups(members) {
+				members = a.delta.state.Groups.transitiveUsers(id)
 			}
+		case len(members) == msgraphExpandLimit || (a.transitiveMembers && hasNestedGroups(members)):
+			// By default, $expand returns only 20 members, for those groups we collect all users by group id.
+			// Groups with nested groups are fetched the same way to get the effective membership.
+			membersToFetch = append(membersToFetch, len(groups))
 		}
 
-		for _, azureMember := range members {
-			azureUserID := azureMember.GetId()
-			if azureUserID == nil {
-				a.logger.Error("Empty group member id", "group", displayName)
-				continue
-			}
-			memberIDs.Add(*azureUserID)
-		}
-		a.maybePrintDebugLogs(id, "azure_members_count", len(memberIDs.ToSlice()))
-
 		groups = append(groups,
 			SourceGroupWithMembers{
 				SourceGroup: AzureGroup{
 					AzureID:     id,
 					DisplayName: displayName,
 				},
-				Members: memberIDs,
 			})
+		groupsMembers = append(groupsMembers, members)
+	}
+
+	// Each group is fetched with its own requests, so they are done concurrently,
+	// results are stored by group index to keep the order.
+	err = runConcurrently(ctx, a.concurrency, len(membersToFetch), func(ctx context.Context, i int) error {
+		index := membersToFetch[i]
+		members, err := a.getGroupMembers(ctx, groups[index].SourceGroup.GetID())
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch all members of group %s", groups[index].SourceGroup.GetID())
+		}
+		groupsMembers[index] = members
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range groups {
+		id := groups[i].SourceGroup.GetID()
+		memberIDs := NewStringSet()
+		for _, azureMember := range groupsMembers[i] {
+			if a.transitiveMembers && !a.isAccount(azureMember) {
+				// Only users and synced service principals are counted, nested groups are expanded, devices are skipped.
+				continue
+			}
+			azureUserID := azureMember.GetId()
+			if azureUserID == nil {
+				a.logger.Error("Empty group member id", "group", groups[i].SourceGroup.GetName())
+				continue
+			}
+			memberIDs.Add(*azureUserID)
+		}
+		a.maybePrintDebugLogs(id, "azure_members_count", len(memberIDs.ToSlice()))
+		groups[i].Members = memberIDs
 	}
 
 	a.logger.Infow("Fetched groups from Azure AD", "got", len(groupsRaw), "skipped", groupsSkipped)
@@ -244,6 +572,29 @@ func (a *AzureReal) maybePrintDebugLogs(id ObjectID, args ...any) {
 }
 
 func (a *AzureReal) getUsersRaw(ctx context.Context, fieldsToSelect []string, filter string) ([]models.Userable, error) {
+	var rawUsers []models.Userable
+	var err error
+	if a.delta != nil {
+		rawUsers, err = a.getUsersFromDelta(ctx, filter)
+	} else {
+		rawUsers, err = a.listUsers(ctx, fieldsToSelect, filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// If user groups filter is specified, filter users by group membership
+	if a.userGroupsFilter != "" {
+		rawUsers, err = a.filterUsersByGroupMembership(ctx, rawUsers)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to filter users by group membership")
+		}
+	}
+
+	return rawUsers, nil
+}
+
+func (a *AzureReal) listUsers(ctx context.Context, fieldsToSelect []string, filter string) ([]models.Userable, error) {
 	// https://learn.microsoft.com/en-us/graph/api/user-list
 	// https://learn.microsoft.com/en-us/graph/aad-advanced-queries
 	headers := abstractions.NewRequestHeaders()
@@ -274,7 +625,7 @@ func (a *AzureReal) getUsersRaw(ctx context.Context, fieldsToSelect []string, fi
 	}
 
 	var rawUsers []models.Userable
-	err = pageIterator.Iterate(context.Background(), func(user models.Userable) bool {
+	err = pageIterator.Iterate(ctx, func(user models.Userable) bool {
 		rawUsers = append(rawUsers, user)
 		// Return true to continue the iteration.
 		return true
@@ -282,15 +633,6 @@ func (a *AzureReal) getUsersRaw(ctx context.Context, fieldsToSelect []string, fi
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to iterate over Azure users")
 	}
-
-	// If user groups filter is specified, filter users by group membership
-	if a.userGroupsFilter != "" {
-		rawUsers, err = a.filterUsersByGroupMembership(ctx, rawUsers)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to filter users by group membership")
-		}
-	}
-
 	return rawUsers, nil
 }
 
@@ -326,6 +668,13 @@ func (a *AzureReal) filterUsersByGroupMembership(ctx context.Context, users []mo
 }
 
 func (a *AzureReal) getGroupsWithMembersRaw(ctx context.Context, fieldsToSelect []string, filter string) ([]models.Groupable, error) {
+	if a.delta != nil {
+		return a.getGroupsFromDelta(ctx, filter)
+	}
+	return a.listGroups(ctx, fieldsToSelect, filter, true)
+}
+
+func (a *AzureReal) listGroups(ctx context.Context, fieldsToSelect []string, filter string, expandMembers bool) ([]models.Groupable, error) {
 	// https://learn.microsoft.com/en-us/graph/api/group-list
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("ConsistencyLevel", "eventual")
@@ -336,9 +685,11 @@ func (a *AzureReal) getGroupsWithMembersRaw(ctx context.Context, fieldsToSelect
 			Count:  &count,
 			Filter: &filter,
 			Select: fieldsToSelect,
-			Expand: []string{"members($select=id)"},
 		},
 	}
+	if expandMembers {
+		requestConfig.QueryParameters.Expand = []string{"members($select=id)"}
+	}
 	result, err := a.graphClient.Groups().Get(ctx, requestConfig)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get groups")
@@ -355,7 +706,7 @@ func (a *AzureReal) getGroupsWithMembersRaw(ctx context.Context, fieldsToSelect
 	}
 
 	var rawGroups []models.Groupable
-	err = pageIterator.Iterate(context.Background(), func(group models.Groupable) bool {
+	err = pageIterator.Iterate(ctx, func(group models.Groupable) bool {
 		rawGroups = append(rawGroups, group)
 		// Return true to continue the iteration.
 		return true
@@ -366,7 +717,40 @@ func (a *AzureReal) getGroupsWithMembersRaw(ctx context.Context, fieldsToSelect
 	return rawGroups, nil
 }
 
+// isAccount is true for members which are synced as YTsaurus users.
+func (a *AzureReal) isAccount(member models.DirectoryObjectable) bool {
+	switch member.(type) {
+	case models.Userable:
+		return true
+	case models.ServicePrincipalable:
+		return a.servicePrincipals != nil
+	default:
+		return false
+	}
+}
+
+func hasNestedGroups(members []models.DirectoryObjectable) bool {
+	for _, member := range members {
+		if _, isGroup := member.(models.Groupable); isGroup {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *AzureReal) getGroupMembers(ctx context.Context, groupID string) ([]models.DirectoryObjectable, error) {
+	if a.transitiveMembers {
+		members, err := a.getGroupTransitiveUsers(ctx, groupID)
+		if err != nil || a.servicePrincipals == nil {
+			return members, err
+		}
+		servicePrincipals, err := a.getGroupTransitiveServicePrincipals(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+		return append(members, servicePrincipals...), nil
+	}
+
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("ConsistencyLevel", "eventual")
 
@@ -393,7 +777,7 @@ func (a *AzureReal) getGroupMembers(ctx context.Context, groupID string) ([]mode
 	}
 
 	var rawMembers []models.DirectoryObjectable
-	err = pageIterator.Iterate(context.Background(), func(pageItem models.DirectoryObjectable) bool {
+	err = pageIterator.Iterate(ctx, func(pageItem models.DirectoryObjectable) bool {
 		rawMembers = append(rawMembers, pageItem)
 		// Return true to continue the iteration.
 		return true
@@ -405,3 +789,128 @@ func (a *AzureReal) getGroupMembers(ctx context.Context, groupID string) ([]mode
 	return rawMembers, nil
 
 }
+
+// getGroupTransitiveUsers returns users which are members of the group directly or via nested groups.
+func (a *AzureReal) getGroupTransitiveUsers(ctx context.Context, groupID string) ([]models.DirectoryObjectable, error) {
+	// https://learn.microsoft.com/en-us/graph/api/group-list-transitivemembers
+	// OData cast to microsoft.graph.user is an advanced query, which requires $count and eventual consistency.
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+	count := true
+	configuration := &msgraphgroups.ItemTransitiveMembersGraphUserRequestBuilderGetRequestConfiguration{
+		Headers: headers,
+		QueryParameters: &msgraphgroups.ItemTransitiveMembersGraphUserRequestBuilderGetQueryParameters{
+			Count:  &count,
+			Select: []string{"id"},
+		},
+	}
+
+	result, err := a.graphClient.Groups().ByGroupId(groupID).TransitiveMembers().GraphUser().Get(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.Userable](
+		result,
+		a.graphClient.GetAdapter(),
+		models.CreateUserCollectionResponseFromDiscriminatorValue,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create transitive members page iterator")
+	}
+	// Next pages are advanced queries too.
+	pageIterator.SetHeaders(headers)
+
+	var rawMembers []models.DirectoryObjectable
+	err = pageIterator.Iterate(ctx, func(pageItem models.Userable) bool {
+		rawMembers = append(rawMembers, pageItem)
+		// Return true to continue the iteration.
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to iterate over Azure group transitive members")
+	}
+	return rawMembers, nil
+}
+
+// getGroupTransitiveServicePrincipals returns service principals which are members of the group directly or via nested groups.
+func (a *AzureReal) getGroupTransitiveServicePrincipals(ctx context.Context, groupID string) ([]models.DirectoryObjectable, error) {
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+	count := true
+	configuration := &msgraphgroups.ItemTransitiveMembersGraphServicePrincipalRequestBuilderGetRequestConfiguration{
+		Headers: headers,
+		QueryParameters: &msgraphgroups.ItemTransitiveMembersGraphServicePrincipalRequestBuilderGetQueryParameters{
+			Count:  &count,
+			Select: []string{"id"},
+		},
+	}
+
+	result, err := a.graphClient.Groups().ByGroupId(groupID).TransitiveMembers().GraphServicePrincipal().Get(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	pageIterator, err := msgraphcore.NewPageIterator[models.ServicePrincipalable](
+		result,
+		a.graphClient.GetAdapter(),
+		models.CreateServicePrincipalCollectionResponseFromDiscriminatorValue,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create transitive service principals page iterator")
+	}
+	pageIterator.SetHeaders(headers)
+
+	var rawMembers []models.DirectoryObjectable
+	err = pageIterator.Iterate(ctx, func(pageItem models.ServicePrincipalable) bool {
+		rawMembers = append(rawMembers, pageItem)
+		// Return true to continue the iteration.
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to iterate over Azure group transitive service principals")
+	}
+	return rawMembers, nil
+}
+
+// runConcurrently calls fn for indexes from 0 to n-1, at most concurrency calls are run at the same time.
+// The first error cancels context of the other calls and is returned.
+func runConcurrently(ctx context.Context, concurrency int, n int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for range min(concurrency, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+loop:
+	for i := range n {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func getAzureGroupMembers(t *testing.T, azure *AzureReal) map[string]StringSet {
+	groups, err := azure.GetGroupsWithMembers()
+	require.NoError(t, err)
+	members := make(map[string]StringSet)
+	for _, group := range groups {
+		members[group.SourceGroup.GetName()] = group.Members
+	}
+	return members
+}
+
+func TestAzureTransitiveMembers(t *testing.T) {
+	server := newFakeGraphServer(t)
+	server.pageSize = 5
+	var bigMembers []string
+	for i := 1; i <= 21; i++ {
+		id := fmt.Sprintf("user-%d", i)
+		server.addUser(id, fmt.Sprintf("user%d@acme.com", i))
+		bigMembers = append(bigMembers, id)
+	}
+	server.addObject("#microsoft.graph.device", map[string]any{"id": "device-1"})
+	server.addGroup("group-devs", "devs", "user-1", "user-2", "device-1")
+	server.addGroup("group-all", "all", "user-3", "group-devs", "group-cycle")
+	server.addGroup("group-cycle", "cycle", "group-all")
+	// More members than $expand returns.
+	server.addGroup("group-big", "big", bigMembers...)
+
+	azure := newFakeGraphAzure(t, server, &AzureConfig{})
+	require.Equal(t, map[string]StringSet{
+		"devs":  NewStringSetFromItems("user-1", "user-2", "device-1"),
+		"all":   NewStringSetFromItems("user-3", "group-devs", "group-cycle"),
+		"cycle": NewStringSetFromItems("group-all"),
+		"big":   NewStringSetFromItems(bigMembers...),
+	}, getAzureGroupMembers(t, azure))
+
+	server.requests = nil
+	azure = newFakeGraphAzure(t, server, &AzureConfig{TransitiveMembers: true})
+	require.Equal(t, map[string]StringSet{
+		"devs":  NewStringSetFromItems("user-1", "user-2"),
+		"all":   NewStringSetFromItems("user-1", "user-2", "user-3"),
+		"cycle": NewStringSetFromItems("user-1", "user-2", "user-3"),
+		"big":   NewStringSetFromItems(bigMembers...),
+	}, getAzureGroupMembers(t, azure))
+
+	// Members are fetched separately only for groups with nested groups or too many members.
+	transitiveRequests := NewStringSet()
+	for _, path := range server.getRequests() {
+		if path != "/v1.0/groups" {
+			transitiveRequests.Add(path)
+		}
+	}
+	require.Equal(t, NewStringSetFromItems(
+		"/v1.0/groups/group-all/transitiveMembers/graph.user",
+		"/v1.0/groups/group-cycle/transitiveMembers/graph.user",
+		"/v1.0/groups/group-big/transitiveMembers/graph.user",
+	), transitiveRequests)
+}
+
+func TestAzureUserFields(t *testing.T) {
+	server := newFakeGraphServer(t)
+	server.addObject(fakeGraphUserType, map[string]any{
+		"id":                            "alice",
+		"userPrincipalName":             "alice@acme.com",
+		"onPremisesSamAccountName":      "ALICE01",
+		"department":                    "R&D",
+		"jobTitle":                      "Engineer",
+		"extension_0123abcd_costCenter": "CC-42",
+		"extension_0123abcd_level":      3,
+	})
+	// Users without username field are skipped.
+	server.addUser("bob", "bob@acme.com")
+
+	for _, delta := range []*AzureDeltaConfig{nil, {}} {
+		azure := newFakeGraphAzure(t, server, &AzureConfig{
+			UsernameField: "onPremisesSamAccountName",
+			UserFields:    []string{"department", "employeeId", "extension_0123abcd_costCenter", "extension_0123abcd_level"},
+			Delta:         delta,
+		})
+		users, err := azure.GetUsers()
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		require.Equal(t, "ALICE01", users[0].GetName())
+		// Missing fields are omitted, not selected ones are not fetched.
+		require.Equal(t, map[string]any{
+			"department":                    "R&D",
+			"extension_0123abcd_costCenter": "CC-42",
+			"extension_0123abcd_level":      float64(3),
+		}, users[0].(AzureUser).Attributes)
+	}
+
+	// Raw representation doesn't change if fields are not configured.
+	azure := newFakeGraphAzure(t, server, &AzureConfig{UsernameField: "userPrincipalName"})
+	users, err := azure.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	raw, err := users[0].GetRaw()
+	require.NoError(t, err)
+	require.NotContains(t, raw, "username")
+	require.NotContains(t, raw, "attributes")
+	require.Equal(t, "alice@acme.com", users[0].GetName())
+}
+
+func TestAzureServicePrincipals(t *testing.T) {
+	server := newFakeGraphServer(t)
+	server.addUser("alice", "alice@acme.com")
+	server.addServicePrincipal("sp-ci", "CI")
+	server.addServicePrincipal("sp-other", "Other")
+	server.filters["displayName eq 'CI'"] = func(object map[string]any) bool {
+		return object["displayName"] == "CI"
+	}
+	server.addGroup("group-devs", "devs", "alice", "sp-ci", "sp-other")
+	server.addGroup("group-all", "all", "group-devs")
+
+	azure := newFakeGraphAzure(t, server, &AzureConfig{})
+	require.Equal(t, []string{"alice"}, getAzureUserIDs(t, azure))
+
+	servicePrincipalsConfig := &AzureServicePrincipalsConfig{Filter: "displayName eq 'CI'"}
+	azure = newFakeGraphAzure(t, server, &AzureConfig{ServicePrincipals: servicePrincipalsConfig})
+	users, err := azure.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	servicePrincipal := AzureServicePrincipal{
+		AzureID:     "sp-ci",
+		AppID:       "app-sp-ci",
+		DisplayName: "CI",
+		Username:    "robot-CI",
+	}
+	require.Equal(t, servicePrincipal, users[1])
+
+	// Service principals are restored from raw representation as a distinct kind.
+	raw, err := users[1].GetRaw()
+	require.NoError(t, err)
+	require.Equal(t, azureServicePrincipalKind, raw["kind"])
+	restored, err := azure.CreateUserFromRaw(raw)
+	require.NoError(t, err)
+	require.Equal(t, &servicePrincipal, restored)
+	restored, err = azure.CreateUserFromRaw(map[string]any{"id": "alice", "principal_name": "alice@acme.com"})
+	require.NoError(t, err)
+	require.Equal(t, &AzureUser{AzureID: "alice", PrincipalName: "alice@acme.com"}, restored)
+
+	// Service principals are counted in transitive membership.
+	for _, delta := range []*AzureDeltaConfig{nil, {}} {
+		azure = newFakeGraphAzure(t, server, &AzureConfig{
+			ServicePrincipals: &AzureServicePrincipalsConfig{UsernamePrefix: "sp-"},
+			TransitiveMembers: true,
+			Delta:             delta,
+		})
+		require.Equal(t, map[string]StringSet{
+			"devs": NewStringSetFromItems("alice", "sp-ci", "sp-other"),
+			"all":  NewStringSetFromItems("alice", "sp-ci", "sp-other"),
+		}, getAzureGroupMembers(t, azure))
+		require.Equal(t, []string{"alice", "sp-ci", "sp-other"}, getAzureUserIDs(t, azure))
+	}
+}
+
+func TestAzureAccountEnabledFilterWarning(t *testing.T) {
+	for filter, warned := range map[string]bool{
+		"userType eq 'Member'":                            false,
+		"accountEnabled eq true and userType eq 'Member'": true,
+		"AccountEnabled eq true":                          true,
+	} {
+		core, logs := observer.New(zap.WarnLevel)
+		_, err := newAzureRealWithClient(&AzureConfig{UsersFilter: filter}, zap.New(core).Sugar(), nil)
+		require.NoError(t, err)
+		require.Equal(t, warned, logs.FilterMessageSnippet("accountEnabled").Len() == 1, filter)
+	}
+}
+
+func TestAzureServicePrincipalsSameDisplayName(t *testing.T) {
+	server := newFakeGraphServer(t)
+	server.addServicePrincipal("sp-ci", "CI")
+	server.addServicePrincipal("sp-deploy-prod", "Deploy")
+	server.addServicePrincipal("sp-deploy-test", "Deploy")
+
+	azure := newFakeGraphAzure(t, server, &AzureConfig{ServicePrincipals: &AzureServicePrincipalsConfig{}})
+	users, err := azure.GetUsers()
+	require.NoError(t, err)
+	var usernames []string
+	for _, user := range users {
+		usernames = append(usernames, user.(AzureServicePrincipal).Username)
+	}
+	require.Equal(t, []string{"robot-CI", "robot-Deploy-app-sp-deploy-prod", "robot-Deploy-app-sp-deploy-test"}, usernames)
+}
+
+func TestAzureDisabledUsers(t *testing.T) {
+	server := newFakeGraphServer(t)
+	server.addUser("alice", "alice@acme.com")
+	server.addObject(fakeGraphUserType, map[string]any{
+		"id":                "bob",
+		"userPrincipalName": "bob@acme.com",
+		"accountEnabled":    false,
+	})
+	// Users without the field are enabled.
+	server.addObject(fakeGraphUserType, map[string]any{"id": "carol", "userPrincipalName": "carol@acme.com"})
+
+	azure := newFakeGraphAzure(t, server, &AzureConfig{})
+	users, err := azure.GetUsers()
+	require.NoError(t, err)
+	disabled := make(map[string]bool)
+	for _, user := range users {
+		disabled[user.GetID()] = user.IsDisabled()
+	}
+	require.Equal(t, map[string]bool{"alice": false, "bob": true, "carol": false}, disabled)
+}
+
+func TestAzureGroupOwners(t *testing.T) {
+	server := newFakeGraphServer(t)
+	server.addUser("alice", "alice@acme.com")
+	server.addServicePrincipal("sp-ci", "CI")
+	server.addGroup("group-devs", "devs", "alice")
+	server.setOwners("group-devs", "alice", "sp-ci")
+	server.addGroup("group-qa", "qa")
+
+	azure := newFakeGraphAzure(t, server, &AzureConfig{})
+	groups, err := azure.GetGroupsWithMembers()
+	require.NoError(t, err)
+	for _, group := range groups {
+		require.Nil(t, group.Managers)
+	}
+
+	azure = newFakeGraphAzure(t, server, &AzureConfig{GroupOwners: true})
+	groups, err = azure.GetGroupsWithMembers()
+	require.NoError(t, err)
+	managers := make(map[string]StringSet)
+	for _, group := range groups {
+		managers[group.SourceGroup.GetName()] = group.Managers
+	}
+	require.Equal(t, map[string]StringSet{
+		"devs": NewStringSetFromItems("alice", "sp-ci"),
+		"qa":   NewStringSet(),
+	}, managers)
+}
+
+func TestAzureConcurrentGroupMembers(t *testing.T) {
+	server := newFakeGraphServer(t)
+	for i := range 30 {
+		server.addUser(fmt.Sprintf("user-%02d", i), fmt.Sprintf("user-%02d@acme.com", i))
+	}
+	// Groups with more members than $expand returns are fetched separately.
+	for i := range 10 {
+		var memberIDs []string
+		for j := range 21 + i {
+			memberIDs = append(memberIDs, fmt.Sprintf("user-%02d", j))
+		}
+		server.addGroup(fmt.Sprintf("group-%02d", i), fmt.Sprintf("big-%02d", i), memberIDs...)
+	}
+	server.addGroup("group-small", "small", "user-00")
+
+	getGroups := func(concurrency int) []SourceGroupWithMembers {
+		azure := newFakeGraphAzure(t, server, &AzureConfig{Concurrency: concurrency, GroupOwners: true})
+		groups, err := azure.GetGroupsWithMembers()
+		require.NoError(t, err)
+		return groups
+	}
+	expected := getGroups(1)
+	require.Len(t, expected, 11)
+	for i, group := range expected[:10] {
+		require.Equal(t, fmt.Sprintf("big-%02d", i), group.SourceGroup.GetName())
+		require.Equal(t, 21+i, group.Members.Cardinality())
+	}
+	require.Equal(t, 1, server.getMaxInFlight())
+
+	server.responseDelay = 20 * time.Millisecond
+	require.Equal(t, expected, getGroups(4))
+	require.Greater(t, server.getMaxInFlight(), 1)
+	require.LessOrEqual(t, server.getMaxInFlight(), 4)
+}
+
+func TestAzureNegativeConcurrency(t *testing.T) {
+	_, err := newAzureRealWithClient(&AzureConfig{Concurrency: -1}, getDevelopmentLogger(), nil)
+	require.ErrorContains(t, err, "concurrency should be positive")
+}
+
+func TestRunConcurrently(t *testing.T) {
+	results := make([]int, 100)
+	var running, maxRunning atomic.Int32
+	err := runConcurrently(context.Background(), 3, len(results), func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			current := maxRunning.Load()
+			if n <= current || maxRunning.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		results[i] = i * i
+		return nil
+	})
+	require.NoError(t, err)
+	for i, result := range results {
+		require.Equal(t, i*i, result)
+	}
+	require.LessOrEqual(t, maxRunning.Load(), int32(3))
+
+	// The first error is returned and cancels the other calls.
+	var calls atomic.Int32
+	err = runConcurrently(context.Background(), 2, 100, func(ctx context.Context, i int) error {
+		calls.Add(1)
+		if i == 1 {
+			return errors.New("failed")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.EqualError(t, err, "failed")
+	require.Less(t, calls.Load(), int32(100))
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
@@ -33,6 +35,11 @@ type AppConfig struct {
 	// BanBeforeRemoveDuration is a duration of a graceful ban before finally removing the user from YTsaurus.
 	// If it is not specified, user will be removed straight after user was found to be missing from source (Azure or Ldap).
 	BanBeforeRemoveDuration time.Duration `yaml:"ban_before_remove_duration"`
+
+	// DisabledUserRemoveDuration is a duration after which users disabled in the source (and banned in YTsaurus)
+	// are removed. If it is not specified, disabled users are kept banned until they are enabled again.
+	// If it is specified, disabled users which don't exist in YTsaurus are not created, so removed ones don't reappear.
+	DisabledUserRemoveDuration time.Duration `yaml:"disabled_user_remove_duration"`
 }
 
 type ReplacementPair struct {
@@ -44,11 +51,14 @@ type AzureConfig struct {
 	Tenant             string `yaml:"tenant"`
 	ClientID           string `yaml:"client_id"`
 	ClientSecretEnvVar string `yaml:"client_secret_env_var"` // default: "AZURE_CLIENT_SECRET"
+	// Auth selects authentication method, client secret from ClientSecretEnvVar is used if it is not specified.
+	Auth *AzureAuthConfig `yaml:"auth,omitempty"`
 
 	// We sync 3 entities independently: users, groups, and memberships.
 	//
 	// USERS are filtered using TWO filters applied sequentially:
-	// 1. `users_filter` - MS Graph $filter for user requests (e.g., accountEnabled eq true)
+	// 1. `users_filter` - MS Graph $filter for user requests (e.g., userType eq 'Member').
+	//    Disabled users are banned, so the filter shouldn't exclude them with accountEnabled condition.
 	// 2. `user_groups_filter` - MS Graph $filter for group requests to get groups whose members will be synced as users
 	//    This is needed because MS Graph user API doesn't support filtering by group membership.
 	//    Only users who match BOTH filters will be synced (users_filter AND membership in user_groups_filter groups).
@@ -66,28 +76,149 @@ type AzureConfig struct {
 	UserGroupsFilter string `yaml:"user_groups_filter"` // Filter for MS Graph groups API to determine which users to sync
 	GroupsFilter     string `yaml:"groups_filter"`      // Filter for MS Graph groups API to determine which groups to sync
 
+	// UsernameField is MS Graph user property which is used as YTsaurus username,
+	// for example, `onPremisesSamAccountName`, `employeeId` or `extension_<app id without dashes>_<name>`.
+	// Users with empty value are skipped. Default: `userPrincipalName`.
+	UsernameField string `yaml:"username_field"`
+	// UserFields are MS Graph user properties, which are selected in addition to the default ones
+	// and copied into the source attribute of YTsaurus user under `attributes` key.
+	// For example, `[department, employeeId, extension_<app id without dashes>_costCenter]`.
+	UserFields []string `yaml:"user_fields"`
+
+	// ServicePrincipals enables sync of service principals (applications and managed identities) as YTsaurus users,
+	// so automation identities which are members of the synced groups get access too.
+	ServicePrincipals *AzureServicePrincipalsConfig `yaml:"service_principals,omitempty"`
+
+	// AppRoles makes app roles of the enterprise application be synced as groups instead of security groups,
+	// so access is managed in its "Users and groups" blade. Role members are users (and service principals)
+	// assigned to the role directly or via assigned groups. groups_filter and group_owners aren't used in this mode.
+	AppRoles *AzureAppRolesConfig `yaml:"app_roles,omitempty"`
+
+	// Concurrency limits the number of groups, whose members or owners are fetched at the same time. Default: 8.
+	Concurrency int `yaml:"concurrency"`
+
+	// GroupOwners enables fetching of group owners, which are allowed to manage the corresponding YTsaurus groups:
+	// the sync maintains ACE allowing them `write` in group's @acl, its subjects are recorded in @synced_managers.
+	// If it is turned off, the ACE is removed on the next sync.
+	GroupOwners bool `yaml:"group_owners"`
+
+	// TransitiveMembers makes groups contain users which are members of nested groups,
+	// so membership in YTsaurus reflects the effective one. Only users are counted, nested groups themselves are not.
+	TransitiveMembers bool `yaml:"transitive_members"`
+
+	// Delta enables incremental sync with MS Graph delta queries: only changes since the previous sync are fetched
+	// and applied to the cached users and groups. If it is not specified, all users and groups are fetched every sync.
+	Delta *AzureDeltaConfig `yaml:"delta,omitempty"`
+
 	// TODO(nadya73): support for ldap also, but with other name.
 	// GroupsDisplayNameSuffixPostFilter is deprecated: use GroupsDisplayNameRegexPostFilter instead.
 	GroupsDisplayNameSuffixPostFilter string `yaml:"groups_display_name_suffix_post_filter"`
 	// GroupsDisplayNameRegexPostFilter applied to the fetched groups display names.
-	GroupsDisplayNameRegexPostFilter string        `yaml:"groups_display_name_regex_post_filter"`
-	Timeout                          time.Duration `yaml:"timeout"`
+	GroupsDisplayNameRegexPostFilter string `yaml:"groups_display_name_regex_post_filter"`
+
+	// Timeout limits each MS Graph request attempt (a page for paged lists), including reading the response.
+	Timeout time.Duration `yaml:"timeout"`
+	// FetchTimeout limits fetching all users or all groups with their members, including retries.
+	FetchTimeout time.Duration `yaml:"fetch_timeout"`
+	// Retry configures retries of throttled (429), unavailable (503, 504) and timed out requests.
+	Retry AzureRetryConfig `yaml:"retry"`
 
 	// TODO(nadya73): support for ldap also, but with other name.
 	// DebugAzureIDs is a list of ids for which app will print more debug info in logs.
 	DebugAzureIDs []string `yaml:"debug_azure_ids"`
 }
 
+type AzureServicePrincipalsConfig struct {
+	// Filter is MS Graph $filter for service principals, for example, `tags/any(t:t eq 'ytsaurus')`.
+	Filter string `yaml:"filter"`
+	// UsernamePrefix is prepended to display name of service principal to get YTsaurus username,
+	// so robots are distinguished from people. Default: `robot-`.
+	// Display names aren't unique, so app id is appended to usernames of principals with the same display name.
+	UsernamePrefix string `yaml:"username_prefix"`
+}
+
+type AzureAppRolesConfig struct {
+	// ServicePrincipalID is object id of the enterprise application (service principal), whose roles are synced.
+	// Group name is role value, or its display name if value is empty.
+	// Assignments to the default access role are synced as a group named after the application.
+	ServicePrincipalID string `yaml:"service_principal_id"`
+}
+
+type AzureRetryConfig struct {
+	// MaxRetries is a number of retries after the first attempt, 0 means the default (5), negative disables retries.
+	MaxRetries int `yaml:"max_retries"`
+	// InitialDelay is a delay before the first retry, it is doubled for each next one up to MaxDelay.
+	// Retry-After response header overrides it.
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	MaxDelay     time.Duration `yaml:"max_delay"`
+}
+
+type AzureAuthConfig struct {
+	// Method is one of:
+	// - `client_secret` (default): secret of the app registration from ClientSecretEnvVar;
+	// - `client_certificate`: certificate of the app registration from CertificateFile;
+	// - `managed_identity`: managed identity of Azure VM or other Azure host;
+	// - `workload_identity`: federated token from FederatedTokenFile, e.g. AKS workload identity.
+	Method string `yaml:"method"`
+	// CertificateFile is a path to PEM or PFX (PKCS#12) file with certificate and its private key.
+	CertificateFile string `yaml:"certificate_file"`
+	// CertificatePasswordEnvVar is a name of env variable with password of encrypted CertificateFile.
+	CertificatePasswordEnvVar string `yaml:"certificate_password_env_var"`
+	// SendCertificateChain makes certificate chain sent with token requests, required for subject name/issuer auth.
+	SendCertificateChain bool `yaml:"send_certificate_chain"`
+	// ManagedIdentityClientID is a client id of user-assigned managed identity.
+	// If it is not specified, system-assigned identity is used.
+	ManagedIdentityClientID string `yaml:"managed_identity_client_id"`
+	// FederatedTokenFile is a path to the file with federated token, which is exchanged for access token
+	// of the app registration (Tenant and ClientID). Default: AZURE_FEDERATED_TOKEN_FILE env var value.
+	FederatedTokenFile string `yaml:"federated_token_file"`
+}
+
+// AzureDeltaConfig configures incremental sync with delta queries.
+// Delta queries can't be filtered, so all users and groups of the tenant are cached,
+// while users_filter and groups_filter are applied by listing ids of the matching objects every sync.
+type AzureDeltaConfig struct {
+	// StateFile is a local file where delta links and cached objects are persisted, so incremental sync
+	// continues after restart. If neither StateFile nor StateYtsaurusPath is specified, state is kept in memory only.
+	StateFile string `yaml:"state_file"`
+	// StateYtsaurusPath is a path of YTsaurus file node where state is persisted instead of the local file.
+	// Connection settings are taken from the ytsaurus section.
+	StateYtsaurusPath string `yaml:"state_ytsaurus_path"`
+	// FullSyncInterval is an interval between full syncs which guard against drift. Default: 24h.
+	// Full sync is also done if MS Graph responds that the delta link has expired.
+	FullSyncInterval time.Duration `yaml:"full_sync_interval"`
+}
+
 type LdapUsersConfig struct {
 	// A filter for getting users.
 	// For example, `(objectClass=account)`.
 	Filter string `yaml:"filter"`
+	// SearchBases is a list of subtrees where users are searched, results are merged.
+	// If it is not specified, LdapConfig.BaseDN is searched with `sub` scope.
+	SearchBases []LdapSearchBase `yaml:"search_bases"`
 	// An attribute type which will be used as @name attribute.
 	// For example, `cn`.
 	UsernameAttributeType string `yaml:"username_attribute_type"`
 	// For example, `uid`.
 	UIDAttributeType       string  `yaml:"uid_attribute_type"`
 	FirstNameAttributeType *string `yaml:"first_name_attribute_type"`
+	// IDAttributeType is an immutable attribute type which identifies users, so renames are handled as updates.
+	// For example, `entryUUID` or `objectGUID` (binary value is converted to the canonical string form).
+	// If it is not specified, UIDAttributeType value is used as ID.
+	IDAttributeType string `yaml:"id_attribute_type"`
+	// MemberOfAttributeType is an attribute type of user entries with DNs of the groups user belongs to.
+	// It is used with `member_of` groups membership type. Default: `memberOf`.
+	MemberOfAttributeType string `yaml:"member_of_attribute_type"`
+	// DisabledAttributeType is an attribute type which marks disabled accounts.
+	// Disabled users are banned in YTsaurus (instead of being removed) and unbanned once enabled again.
+	// For `userAccountControl` with empty DisabledAttributeValue, AD ACCOUNTDISABLE flag (0x2) is checked.
+	// Otherwise user is disabled if the attribute value is equal to DisabledAttributeValue (case-insensitive),
+	// for example, `nsAccountLock` and `TRUE`.
+	DisabledAttributeType  string `yaml:"disabled_attribute_type"`
+	DisabledAttributeValue string `yaml:"disabled_attribute_value"`
+	// Attributes are copied into the source attribute of YTsaurus user under `attributes` key.
+	// For example, `{email: mail, display_name: displayName}`.
+	Attributes LdapAttributeMappings `yaml:"attributes"`
 	// A list of usernames for which app will print more debug info in logs.
 	DebugUsernames []string `yaml:"debug_usernames"`
 }
@@ -96,24 +227,158 @@ type LdapGroupsConfig struct {
 	// A filter for getting groups.
 	// For example, `(objectClass=posixGroup)`.
 	Filter string `yaml:"filter"`
+	// SearchBases is a list of subtrees where groups are searched, results are merged.
+	// If it is not specified, LdapConfig.BaseDN is searched with `sub` scope.
+	SearchBases []LdapSearchBase `yaml:"search_bases"`
 	// An attribute type which will be used as @name attribute.
 	// For example, `cn`.
 	GroupnameAttributeType string `yaml:"groupname_attribute_type"`
+	// IDAttributeType is an immutable attribute type which identifies groups, so renames are handled as updates.
+	// If it is not specified, GroupnameAttributeType value is used as ID.
+	IDAttributeType string `yaml:"id_attribute_type"`
 	// An attribute type which will be used for getting group members.
-	// For example, `memberUid`.
+	// For example, `memberUid` for `uid` membership type or `member` for `dn` membership type.
 	MemberUIDAttributeType string `yaml:"member_uid_attribute_type"`
+	// MembershipType defines what values of MemberUIDAttributeType are:
+	// - `uid` (default): values of users' UIDAttributeType (posixGroup `memberUid` style);
+	// - `dn`: DNs of user entries (groupOfNames `member`, groupOfUniqueNames `uniqueMember`, AD `member`),
+	//   values which don't match any user found by the users filter are skipped;
+	// - `member_of`: members are collected from users' MemberOfAttributeType values, for directories
+	//   where group entries have no member lists readable by the bind account.
+	//   Values which don't match any group found by the groups filter are skipped, MemberUIDAttributeType is not used.
+	//   Note that AD doesn't update users' change attribute on membership changes, so with incremental sync
+	//   they are picked up by the full sync only.
+	MembershipType string `yaml:"membership_type"`
+	// NestedGroups enables transitive expansion of nested groups, so groups contain every effective member.
+	// It requires `dn` membership type. Possible values:
+	// - empty (default): nested groups are not expanded;
+	// - `recursive`: nested groups are resolved on the app side with cycle detection,
+	//   nested groups which don't match the filter are fetched by their DNs;
+	// - `in_chain`: members are fetched by a separate search per group with
+	//   AD LDAP_MATCHING_RULE_IN_CHAIN (1.2.840.113556.1.4.1941), works with Active Directory only.
+	NestedGroups string `yaml:"nested_groups"`
+	// Attributes are copied into the source attribute of YTsaurus group under `attributes` key.
+	Attributes LdapAttributeMappings `yaml:"attributes"`
 
 	// A list of groupnames for which app will print more debug info in logs.
 	DebugGroupnames []string `yaml:"debug_groupnames"`
 }
 
+type LdapTLSConfig struct {
+	// StartTLS upgrades plain `ldap://` connection with StartTLS extended operation.
+	// For `ldaps://` addresses TLS is used anyway and StartTLS should not be enabled.
+	StartTLS bool `yaml:"start_tls"`
+	// CAFile is a path to PEM bundle with CA certificates used to verify the server certificate.
+	// If it is not specified, system CA pool is used.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are paths to PEM client certificate and its key,
+	// they should be specified together if server requires client certificates.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the host name used to verify the server certificate.
+	// If it is not specified, host from the address is used.
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify disables server certificate verification. Use it for test benches only.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+type LdapSearchBase struct {
+	BaseDN string `yaml:"base_dn"`
+	// Scope is one of `base` (the base entry only), `one` (direct children) or `sub` (whole subtree, default).
+	Scope string `yaml:"scope"`
+}
+
+// LdapAttributeMapping describes which LDAP attribute is copied into the stored source record.
+// In config it can be specified either as an attribute type or as a mapping with the fields below.
+type LdapAttributeMapping struct {
+	AttributeType string `yaml:"attribute_type"`
+	// MultiValued attributes are stored as a sorted list of all values, otherwise only the first value is stored.
+	MultiValued bool `yaml:"multi_valued"`
+}
+
+func (m *LdapAttributeMapping) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*m = LdapAttributeMapping{AttributeType: value.Value}
+		return nil
+	}
+	type plain LdapAttributeMapping
+	return value.Decode((*plain)(m))
+}
+
+// LdapAttributeMappings maps keys of the stored source record to LDAP attributes.
+type LdapAttributeMappings map[string]LdapAttributeMapping
+
+// LdapAddresses is a list of LDAP server URLs, in config it can be specified either as a single string or as a list.
+type LdapAddresses []string
+
+func (a *LdapAddresses) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*a = LdapAddresses{value.Value}
+		return nil
+	}
+	var addresses []string
+	if err := value.Decode(&addresses); err != nil {
+		return err
+	}
+	*a = addresses
+	return nil
+}
+
+type LdapKerberosConfig struct {
+	// Username and Realm of the principal the keytab is issued for (e.g. `ytsaurus-sync` and `ACME.COM`).
+	Username string `yaml:"username"`
+	Realm    string `yaml:"realm"`
+	// KeytabFile is a path to the keytab with the principal keys.
+	KeytabFile string `yaml:"keytab_file"`
+	// Krb5ConfFile is a path to krb5.conf with the realm settings. Default: /etc/krb5.conf.
+	Krb5ConfFile string `yaml:"krb5_conf_file"`
+	// ServicePrincipal of the LDAP server. Default: `ldap/<host>` with the host of the address being connected to.
+	ServicePrincipal string `yaml:"service_principal"`
+}
+
 type LdapConfig struct {
-	Address            string           `yaml:"address"`
+	// Address is a server URL (e.g. `ldaps://ldap.acme.com`) or a list of them.
+	// Servers are tried in order, on connection problems app fails over to the next one.
+	Address LdapAddresses `yaml:"address"`
+	// Preset fills defaults of users and groups settings for a common directory schema,
+	// explicitly specified settings override the preset ones. Possible values:
+	// - `active_directory`: sAMAccountName names, objectGUID IDs, `dn` membership, disabled userAccountControl;
+	// - `openldap_posix`: posixAccount and posixGroup with `uid` membership, entryUUID IDs;
+	// - `freeipa`: ipaUniqueID IDs, `dn` membership with nested groups, disabled nsAccountLock;
+	// - `group_of_names`: inetOrgPerson and groupOfNames with `dn` membership, entryUUID IDs.
+	Preset string `yaml:"preset"`
+	// BindMethod is `simple` (default) for bind_dn with the password from bind_password_env_var,
+	// `sasl_external` for authentication with the TLS client certificate
+	// or `sasl_gssapi` for Kerberos authentication with the keytab.
+	BindMethod         string           `yaml:"bind_method"`
 	BindDN             string           `yaml:"bind_dn"`
 	BindPasswordEnvVar string           `yaml:"bind_password_env_var"`
 	Users              LdapUsersConfig  `yaml:"users"`
 	Groups             LdapGroupsConfig `yaml:"groups"`
 	BaseDN             string           `yaml:"base_dn"`
+	// PageSize is a number of entries requested per page with simple paged results control (RFC 2696).
+	// It should not exceed the server size limit (1000 for AD by default). Default: 500.
+	PageSize uint32 `yaml:"page_size"`
+	// Timeout limits dialing and waiting for the response to each request (a page for paged search).
+	// On timeout the connection is reestablished, so idle connections silently dropped
+	// by a firewall don't hang the sync. Default: 30s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// TLS settings for `ldaps://` addresses or StartTLS.
+	TLS *LdapTLSConfig `yaml:"tls,omitempty"`
+	// Kerberos settings for `sasl_gssapi` bind method.
+	Kerberos *LdapKerberosConfig `yaml:"kerberos,omitempty"`
+	// Incremental enables incremental sync, if it is not specified the whole directory is read every sync.
+	Incremental *LdapIncrementalConfig `yaml:"incremental,omitempty"`
+}
+
+// LdapIncrementalConfig configures incremental sync: only entries changed since the previous sync are fetched
+// with all attributes and applied to the cached entries, while removals are detected by listing DNs only.
+type LdapIncrementalConfig struct {
+	// ChangeAttributeType is an attribute type which grows on every entry change and supports `>=` filter,
+	// e.g. `modifyTimestamp` (default) or AD `uSNChanged`.
+	ChangeAttributeType string `yaml:"change_attribute_type"`
+	// FullSyncInterval is an interval between full syncs which guard against drift. Default: 24h.
+	FullSyncInterval time.Duration `yaml:"full_sync_interval"`
 }
 
 type YtsaurusConfig struct {
@@ -32,8 +32,9 @@ func TestAzureConfig(t *testing.T) {
 
 	require.Equal(t, "acme.onmicrosoft.com", cfg.Azure.Tenant)
 	require.Equal(t, "abcdefgh-a000-b111-c222-abcdef123456", cfg.Azure.ClientID)
-	require.Equal(t, 1*time.Second, cfg.Azure.Timeout)
-	require.Equal(t, "(accountEnabled eq true) and (userType eq 'Member')", cfg.Azure.UsersFilter)
+	require.Equal(t, 30*time.Second, cfg.Azure.Timeout)
+	require.Equal(t, 10*time.Minute, cfg.Azure.FetchTimeout)
+	require.Equal(t, "userType eq 'Member'", cfg.Azure.UsersFilter)
 	require.Equal(t, "displayName -ne ''", cfg.Azure.GroupsFilter)
 	require.Equal(t, `\.dev$`, cfg.Azure.GroupsDisplayNameRegexPostFilter)
 
@@ -73,8 +74,9 @@ func TestLdapConfig(t *testing.T) {
 
 	require.Equal(t, "dc=example,dc=org", cfg.Ldap.BaseDN)
 	require.Equal(t, "cn=admin,dc=example,dc=org", cfg.Ldap.BindDN)
-	require.Equal(t, "localhost:10210", cfg.Ldap.Address)
+	require.Equal(t, LdapAddresses{"localhost:10210"}, cfg.Ldap.Address)
 	require.Equal(t, "LDAP_PASSWORD", cfg.Ldap.BindPasswordEnvVar)
+	require.Equal(t, uint32(500), cfg.Ldap.PageSize)
 
 	require.Equal(t, "(&(objectClass=posixAccount)(ou=People))", cfg.Ldap.Users.Filter)
 	require.Equal(t, "cn", cfg.Ldap.Users.UsernameAttributeType)
@@ -99,3 +101,38 @@ func TestLdapConfig(t *testing.T) {
 	require.NoError(t, err)
 	logger.Debugw("test logging message", "key", "val")
 }
+
+func TestLdapAddresses(t *testing.T) {
+	cfg, err := unmarshallConfig([]byte(`
+ldap:
+  address: ldap://ldap1.acme.com
+`))
+	require.NoError(t, err)
+	require.Equal(t, LdapAddresses{"ldap://ldap1.acme.com"}, cfg.Ldap.Address)
+
+	cfg, err = unmarshallConfig([]byte(`
+ldap:
+  address:
+    - ldap://ldap1.acme.com
+    - ldap://ldap2.acme.com
+`))
+	require.NoError(t, err)
+	require.Equal(t, LdapAddresses{"ldap://ldap1.acme.com", "ldap://ldap2.acme.com"}, cfg.Ldap.Address)
+}
+
+func TestLdapAttributeMappings(t *testing.T) {
+	cfg, err := unmarshallConfig([]byte(`
+ldap:
+  users:
+    attributes:
+      email: mail
+      groups:
+        attribute_type: memberOf
+        multi_valued: true
+`))
+	require.NoError(t, err)
+	require.Equal(t, LdapAttributeMappings{
+		"email":  {AttributeType: "mail"},
+		"groups": {AttributeType: "memberOf", MultiValued: true},
+	}, cfg.Ldap.Users.Attributes)
+}
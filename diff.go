@@ -16,6 +16,9 @@ type SourceUser interface {
 	GetID() ObjectID
 	GetName() string
 	GetRaw() (map[string]any, error)
+	// IsDisabled is true if the user account is disabled in the Source,
+	// such users are kept in YTsaurus, but banned until they are enabled again.
+	IsDisabled() bool
 }
 
 type SourceGroup interface {
@@ -28,6 +31,9 @@ type SourceGroupWithMembers struct {
 	SourceGroup SourceGroup
 	// Members is a set of strings, representing users' ObjectID.
 	Members StringSet
+	// Managers is a set of users' ObjectID, which are allowed to manage the group.
+	// If it is nil, the source doesn't provide managers and the managers ACE added by the sync earlier is removed.
+	Managers StringSet
 }
 
 func (a *App) syncOnce() {
@@ -73,12 +79,12 @@ func (a *App) syncUsers() (map[ObjectID]YtsaurusUser, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to calculate users diff")
 	}
-	if a.isRemoveLimitReached(len(diff.remove)) {
-		return nil, fmt.Errorf("delete limit in one cycle reached: %d %v", len(diff.remove), diff.remove)
+	if removeCount := len(diff.remove) + len(diff.removeDisabled); a.isRemoveLimitReached(removeCount) {
+		return nil, fmt.Errorf("delete limit in one cycle reached: %d %v %v", removeCount, diff.remove, diff.removeDisabled)
 	}
 
 	var bannedCount, removedCount int
-	var createErrCount, updateErrCount, banOrremoveErrCount int
+	var createErrCount, updateErrCount, banOrremoveErrCount, banDisabledErrCount, removeDisabledErrCount int
 	for _, user := range diff.remove {
 		wasBanned, wasRemoved, removeErr := a.banOrRemoveUser(user)
 		if removeErr != nil {
@@ -92,6 +98,13 @@ func (a *App) syncUsers() (map[ObjectID]YtsaurusUser, error) {
 			removedCount++
 		}
 	}
+	for _, user := range diff.removeDisabled {
+		err = a.ytsaurus.RemoveUser(user.Username)
+		if err != nil {
+			removeDisabledErrCount++
+			a.logger.Errorw("failed to remove disabled user", zap.Error(err), "user", user)
+		}
+	}
 	for _, user := range diff.create {
 		err = a.ytsaurus.CreateUser(user)
 		if err != nil {
@@ -106,6 +119,14 @@ func (a *App) syncUsers() (map[ObjectID]YtsaurusUser, error) {
 			a.logger.Errorw("failed to update user", zap.Error(err), "user", updatedUser)
 		}
 	}
+	// Banning goes after create and update, so users exist and have actual names.
+	for _, user := range diff.ban {
+		err = a.ytsaurus.BanUser(user.Username)
+		if err != nil {
+			banDisabledErrCount++
+			a.logger.Errorw("failed to ban disabled user", zap.Error(err), "user", user)
+		}
+	}
 	a.logger.Infow("Finish syncing users",
 		"created", len(diff.create)-createErrCount,
 		"create_errors", createErrCount,
@@ -114,6 +135,10 @@ func (a *App) syncUsers() (map[ObjectID]YtsaurusUser, error) {
 		"removed", removedCount,
 		"banned", bannedCount,
 		"ban_or_remove_errors", banOrremoveErrCount,
+		"banned_disabled", len(diff.ban)-banDisabledErrCount,
+		"ban_disabled_errors", banDisabledErrCount,
+		"removed_disabled", len(diff.removeDisabled)-removeDisabledErrCount,
+		"remove_disabled_errors", removeDisabledErrCount,
 	)
 	return diff.result, nil
 }
@@ -193,6 +218,21 @@ func (a *App) syncGroups(usersMap map[ObjectID]YtsaurusUser) error {
 		"removed", len(diff.membersToRemove)-removeMemberErrCount,
 		"remove_errors", removeMemberErrCount,
 	)
+
+	var setManagersErrCount int
+	for _, managers := range diff.managersToSet {
+		err = a.ytsaurus.SetGroupManagers(managers.GroupName, managers.Usernames)
+		if err != nil {
+			setManagersErrCount++
+			a.logger.Errorw("failed to set group managers", zap.Error(err), "group", managers.GroupName, "managers", managers.Usernames)
+		}
+	}
+	if len(diff.managersToSet) > 0 {
+		a.logger.Infow("Finish syncing group managers",
+			"set", len(diff.managersToSet)-setManagersErrCount,
+			"set_errors", setManagersErrCount,
+		)
+	}
 	return nil
 }
 
@@ -202,6 +242,7 @@ type groupDiff struct {
 	groupsToUpdate  []UpdatedYtsaurusGroup
 	membersToAdd    []YtsaurusMembership
 	membersToRemove []YtsaurusMembership
+	managersToSet   []YtsaurusGroupManagers
 }
 
 func (a *App) diffGroups(
@@ -212,6 +253,7 @@ func (a *App) diffGroups(
 	var groupsToCreate, groupsToRemove []YtsaurusGroup
 	var groupsToUpdate []UpdatedYtsaurusGroup
 	var membersToAdd, membersToRemove []YtsaurusMembership
+	var managersToSet []YtsaurusGroupManagers
 
 	sourceGroupsWithMembersMap := make(map[ObjectID]SourceGroupWithMembers)
 	for _, group := range sourceGroups {
@@ -241,6 +283,15 @@ func (a *App) diffGroups(
 					Username:  username,
 				})
 			}
+			if sourceGroupWithMembers.Managers != nil {
+				managers := a.buildYtsaurusGroupManagers(sourceGroupWithMembers, usersMap)
+				if managers.Cardinality() > 0 {
+					managersToSet = append(managersToSet, YtsaurusGroupManagers{
+						GroupName: newYtsaurusGroup.Name,
+						Usernames: managers,
+					})
+				}
+			}
 		}
 	}
 
@@ -285,6 +336,20 @@ func (a *App) diffGroups(
 				Username:  username,
 			})
 		}
+
+		// Managers ACE is removed when the source stops providing managers (e.g. group_owners is turned off),
+		// so no privileges are left behind.
+		managers := a.buildYtsaurusGroupManagers(sourceGroupWithMembers, usersMap)
+		oldManagers := ytGroupWithMembers.Managers
+		if oldManagers == nil {
+			oldManagers = NewStringSet()
+		}
+		if !managers.Equal(oldManagers) {
+			managersToSet = append(managersToSet, YtsaurusGroupManagers{
+				GroupName: actualGroupname,
+				Usernames: managers,
+			})
+		}
 	}
 
 	return &groupDiff{
@@ -293,6 +358,7 @@ func (a *App) diffGroups(
 		groupsToRemove:  groupsToRemove,
 		membersToAdd:    membersToAdd,
 		membersToRemove: membersToRemove,
+		managersToSet:   managersToSet,
 	}, nil
 }
 
@@ -300,7 +366,11 @@ type usersDiff struct {
 	create []YtsaurusUser
 	update []UpdatedYtsaurusUser
 	remove []YtsaurusUser
-	result map[ObjectID]YtsaurusUser
+	// ban contains users disabled in the Source which are not banned in YTsaurus yet.
+	ban []YtsaurusUser
+	// removeDisabled contains users which have been disabled in the Source for longer than disabledUserRemoveDuration.
+	removeDisabled []YtsaurusUser
+	result         map[ObjectID]YtsaurusUser
 }
 
 func (a *App) diffUsers(
@@ -323,16 +393,24 @@ func (a *App) diffUsers(
 		resultUsersMap[sourceUser.GetID()] = user
 	}
 
-	var create, remove []YtsaurusUser
+	var create, remove, ban, removeDisabled []YtsaurusUser
 	var update []UpdatedYtsaurusUser
 
 	for objectID, sourceUser := range sourceUsersMap {
 		if _, ok := ytUsersMap[objectID]; !ok {
+			if sourceUser.IsDisabled() && a.disabledUserRemoveDuration > 0 {
+				// Otherwise removed disabled users would be created once again.
+				continue
+			}
 			ytUser, err := a.buildYtsaurusUser(sourceUser)
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to create Ytsaurus user from source user")
 			}
 			create = append(create, ytUser)
+			if sourceUser.IsDisabled() {
+				ban = append(ban, ytUser)
+				ytUser.BannedSince = a.banTime()
+			}
 			resultUsersMap[objectID] = ytUser
 		}
 	}
@@ -344,28 +422,55 @@ func (a *App) diffUsers(
 			delete(resultUsersMap, objectID)
 			continue
 		}
+		if sourceUser.IsDisabled() && ytUser.IsBanned() && a.disabledUserRemoveDuration > 0 &&
+			a.clock.Since(ytUser.BannedSince) > a.disabledUserRemoveDuration {
+			removeDisabled = append(removeDisabled, ytUser)
+			delete(resultUsersMap, objectID)
+			continue
+		}
 		newYtUser, err := a.buildYtsaurusUser(sourceUser)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create Ytsaurus user from source user")
 		}
+		needBan := sourceUser.IsDisabled() && !ytUser.IsBanned()
+		if sourceUser.IsDisabled() {
+			// Disabled user keeps its ban time, so it isn't updated on every sync
+			// and isn't subject to ban_before_remove_duration, which is applied only to removed users.
+			newYtUser.BannedSince = ytUser.BannedSince
+			if needBan {
+				ban = append(ban, newYtUser)
+			}
+		}
 		userChanged, updatedYtUser, err := a.isUserChanged(newYtUser, ytUser)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to check if user was changed")
 		}
-		if !userChanged {
-			continue
+		resultUser := ytUser
+		if userChanged {
+			update = append(update, updatedYtUser)
+			resultUser = updatedYtUser.YtsaurusUser
 		}
-		update = append(update, updatedYtUser)
-		resultUsersMap[objectID] = updatedYtUser.YtsaurusUser
+		if needBan {
+			// Ban is applied after the update, so the resulting user is banned.
+			resultUser.BannedSince = a.banTime()
+		}
+		resultUsersMap[objectID] = resultUser
 	}
 	return &usersDiff{
-		create: create,
-		update: update,
-		remove: remove,
-		result: resultUsersMap,
+		create:         create,
+		update:         update,
+		remove:         remove,
+		ban:            ban,
+		removeDisabled: removeDisabled,
+		result:         resultUsersMap,
 	}, nil
 }
 
+// banTime returns the time which Ytsaurus.BanUser is going to set as @banned_since.
+func (a *App) banTime() time.Time {
+	return a.clock.Now().UTC().Truncate(time.Second)
+}
+
 func (a *App) buildUsername(sourceUser SourceUser) string {
 	username := sourceUser.GetName()
 	if a.usernameReplaces != nil {
@@ -395,7 +500,7 @@ func (a *App) buildSourceUser(ytUser *YtsaurusUser) (SourceUser, error) {
 	return a.source.CreateUserFromRaw(ytUser.SourceRaw)
 }
 
-func (a *App) buildSourceGroup(ytGroup *YtsaurusGroupWithMembers) (SourceUser, error) {
+func (a *App) buildSourceGroup(ytGroup *YtsaurusGroupWithMembers) (SourceGroup, error) {
 	if ytGroup.IsManuallyManaged() {
 		return nil, errors.New("user is manually managed and can't be converted to source user")
 	}
@@ -410,7 +515,7 @@ func (a *App) buildYtsaurusUser(sourceUser SourceUser) (YtsaurusUser, error) {
 	return YtsaurusUser{
 		Username:  a.buildUsername(sourceUser),
 		SourceRaw: sourceRaw,
-		// If we have Source user —> he is not banned.
+		// If we have Source user —> he is not banned (disabled users are handled separately).
 		BannedSince: time.Time{},
 	}, nil
 }
@@ -440,6 +545,21 @@ func (a *App) buildYtsaurusGroupMembers(sourceGroupWithMembers SourceGroupWithMe
 	return members
 }
 
+// buildYtsaurusGroupManagers returns usernames of the group managers, which exist in YTsaurus.
+// It is empty if the source doesn't provide managers.
+func (a *App) buildYtsaurusGroupManagers(sourceGroupWithMembers SourceGroupWithMembers, usersMap map[ObjectID]YtsaurusUser) StringSet {
+	managers := NewStringSet()
+	if sourceGroupWithMembers.Managers == nil {
+		return managers
+	}
+	for id := range sourceGroupWithMembers.Managers.Iter() {
+		if ytUser, ok := usersMap[id]; ok {
+			managers.Add(ytUser.Username)
+		}
+	}
+	return managers
+}
+
 // UpdatedYtsaurusUser is a wrapper for YtsaurusUser, because it is handy to store old username for update,
 // because usernames can be changed.
 type UpdatedYtsaurusUser struct {
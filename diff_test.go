@@ -0,0 +1,170 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.ytsaurus.tech/yt/go/yt"
+	testclock "k8s.io/utils/clock/testing"
+)
+
+func disabledLdapUser(user LdapUser) LdapUser {
+	user.Disabled = true
+	return user
+}
+
+func newDiffTestApp(testTime time.Time) *App {
+	return &App{
+		usernameReplaces:  defaultUsernameReplacements,
+		groupnameReplaces: defaultGroupnameReplacements,
+		banDuration:       24 * time.Hour,
+		source:            &Ldap{config: &LdapConfig{}},
+		clock:             testclock.NewFakePassiveClock(testTime),
+		logger:            getDevelopmentLogger(),
+	}
+}
+
+func TestDiffUsersDisabled(t *testing.T) {
+	testTime := initialTestTime.Add(48 * time.Hour)
+	app := newDiffTestApp(testTime)
+
+	diff, err := app.diffUsers(
+		[]SourceUser{
+			// Alice is new and disabled: she is created and banned.
+			disabledLdapUser(createLdapUser(aliceName)),
+			// Bob was banned long ago: he stays banned and isn't removed.
+			disabledLdapUser(createLdapUser(bobName)),
+			// Carol is enabled again: she is unbanned.
+			createLdapUser(carolName),
+			// Dave has been disabled and updated since the last sync: he is updated and banned.
+			disabledLdapUser(createUpdatedLdapUser("dave")),
+		},
+		[]YtsaurusUser{
+			bannedYtsaurusUser(createYtsaurusUser(bobName), initialTestTime),
+			bannedYtsaurusUser(createYtsaurusUser(carolName), initialTestTime),
+			createYtsaurusUser("dave"),
+		},
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, []YtsaurusUser{createYtsaurusUser(aliceName)}, diff.create)
+	require.Empty(t, diff.remove)
+	require.ElementsMatch(t, []UpdatedYtsaurusUser{
+		{YtsaurusUser: createYtsaurusUser(carolName), OldUsername: carolName},
+		{YtsaurusUser: createUpdatedYtsaurusUser("dave"), OldUsername: "dave"},
+	}, diff.update)
+	require.ElementsMatch(t, []YtsaurusUser{
+		createYtsaurusUser(aliceName),
+		createUpdatedYtsaurusUser("dave"),
+	}, diff.ban)
+
+	require.Equal(t, map[ObjectID]YtsaurusUser{
+		getUserID(aliceName): bannedYtsaurusUser(createYtsaurusUser(aliceName), testTime),
+		getUserID(bobName):   bannedYtsaurusUser(createYtsaurusUser(bobName), initialTestTime),
+		getUserID(carolName): createYtsaurusUser(carolName),
+		getUserID("dave"):    bannedYtsaurusUser(createUpdatedYtsaurusUser("dave"), testTime),
+	}, diff.result)
+}
+
+func TestDiffUsersDisabledRemoveDuration(t *testing.T) {
+	testTime := initialTestTime.Add(48 * time.Hour)
+	app := newDiffTestApp(testTime)
+	app.disabledUserRemoveDuration = 36 * time.Hour
+
+	diff, err := app.diffUsers(
+		[]SourceUser{
+			// Alice is new and disabled: she isn't created, so she doesn't reappear after removal.
+			disabledLdapUser(createLdapUser(aliceName)),
+			// Bob has been disabled for longer than the duration: he is removed.
+			disabledLdapUser(createLdapUser(bobName)),
+			// Carol has been disabled recently: she stays banned.
+			disabledLdapUser(createLdapUser(carolName)),
+		},
+		[]YtsaurusUser{
+			bannedYtsaurusUser(createYtsaurusUser(bobName), initialTestTime),
+			bannedYtsaurusUser(createYtsaurusUser(carolName), testTime.Add(-time.Hour)),
+		},
+	)
+	require.NoError(t, err)
+
+	require.Empty(t, diff.create)
+	require.Empty(t, diff.update)
+	require.Empty(t, diff.remove)
+	require.Empty(t, diff.ban)
+	require.Equal(t, []YtsaurusUser{bannedYtsaurusUser(createYtsaurusUser(bobName), initialTestTime)}, diff.removeDisabled)
+	require.Equal(t, map[ObjectID]YtsaurusUser{
+		getUserID(carolName): bannedYtsaurusUser(createYtsaurusUser(carolName), testTime.Add(-time.Hour)),
+	}, diff.result)
+}
+
+func TestDiffGroupsManagers(t *testing.T) {
+	app := newDiffTestApp(initialTestTime)
+	usersMap := map[ObjectID]YtsaurusUser{
+		getUserID(aliceName): createYtsaurusUser(aliceName),
+		getUserID(bobName):   createYtsaurusUser(bobName),
+	}
+	withManagers := func(group YtsaurusGroup, managers StringSet) YtsaurusGroupWithMembers {
+		return YtsaurusGroupWithMembers{YtsaurusGroup: group, Members: NewStringSet(), Managers: managers}
+	}
+
+	diff, err := app.diffGroups(
+		[]SourceGroupWithMembers{
+			// New group gets managers ACE, unknown users are skipped.
+			{SourceGroup: createLdapGroup("devs"), Members: NewStringSet(), Managers: NewStringSetFromItems(getUserID(aliceName), "unknown")},
+			// Managers are changed.
+			{SourceGroup: createLdapGroup("qa"), Members: NewStringSet(), Managers: NewStringSetFromItems(getUserID(bobName))},
+			// Managers are the same.
+			{SourceGroup: createLdapGroup("hq"), Members: NewStringSet(), Managers: NewStringSetFromItems(getUserID(aliceName))},
+			// All managers are gone: ACE is removed.
+			{SourceGroup: createLdapGroup("ops"), Members: NewStringSet(), Managers: NewStringSet()},
+			// Source doesn't provide managers anymore: ACE added by the sync is removed.
+			{SourceGroup: createLdapGroup("ml"), Members: NewStringSet()},
+			// Source doesn't provide managers and the sync hasn't set them: ACL isn't touched.
+			{SourceGroup: createLdapGroup("hr"), Members: NewStringSet()},
+		},
+		[]YtsaurusGroupWithMembers{
+			withManagers(createYtsaurusGroup("qa"), NewStringSetFromItems(createYtsaurusUser(aliceName).Username)),
+			withManagers(createYtsaurusGroup("hq"), NewStringSetFromItems(createYtsaurusUser(aliceName).Username)),
+			withManagers(createYtsaurusGroup("ops"), NewStringSetFromItems(createYtsaurusUser(aliceName).Username)),
+			withManagers(createYtsaurusGroup("ml"), NewStringSetFromItems(createYtsaurusUser(aliceName).Username)),
+			withManagers(createYtsaurusGroup("hr"), nil),
+		},
+		usersMap,
+	)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []YtsaurusGroupManagers{
+		{GroupName: createYtsaurusGroup("devs").Name, Usernames: NewStringSetFromItems(createYtsaurusUser(aliceName).Username)},
+		{GroupName: createYtsaurusGroup("qa").Name, Usernames: NewStringSetFromItems(createYtsaurusUser(bobName).Username)},
+		{GroupName: createYtsaurusGroup("ops").Name, Usernames: NewStringSet()},
+		{GroupName: createYtsaurusGroup("ml").Name, Usernames: NewStringSet()},
+	}, diff.managersToSet)
+}
+
+func TestBuildYtsaurusGroupACL(t *testing.T) {
+	newManagersACE := func(subjects ...string) yt.ACE {
+		return yt.ACE{
+			Action:          yt.ActionAllow,
+			Subjects:        subjects,
+			Permissions:     []yt.Permission{yt.PermissionWrite},
+			InheritanceMode: ytsaurusObjectOnlyInheritanceMode,
+		}
+	}
+	readACE := yt.ACE{Action: yt.ActionAllow, Subjects: []string{"admins"}, Permissions: []yt.Permission{yt.PermissionWrite}}
+	// ACE written by an admin has the same shape as the one of the sync.
+	adminACE := newManagersACE("carol")
+
+	acl := buildYtsaurusGroupACL([]yt.ACE{readACE, adminACE}, NewStringSet(), NewStringSetFromItems("bob", "alice"))
+	require.Equal(t, []yt.ACE{readACE, adminACE, newManagersACE("alice", "bob")}, acl)
+
+	// Only the recorded managers ACE is replaced, other ACEs are kept.
+	acl = buildYtsaurusGroupACL(acl, NewStringSetFromItems("alice", "bob"), NewStringSetFromItems("carol"))
+	require.Equal(t, []yt.ACE{readACE, adminACE, newManagersACE("carol")}, acl)
+
+	// Only one of the same ACEs is removed.
+	require.Equal(t, []yt.ACE{readACE, adminACE}, buildYtsaurusGroupACL(acl, NewStringSetFromItems("carol"), NewStringSet()))
+
+	// Managers ACE changed by an admin isn't recognized anymore, so it is kept.
+	acl = []yt.ACE{readACE, newManagersACE("alice", "dave")}
+	require.Equal(t, append(acl, newManagersACE("bob")), buildYtsaurusGroupACL(acl, NewStringSetFromItems("alice"), NewStringSetFromItems("bob")))
+}
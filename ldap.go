@@ -1,94 +1,656 @@
 package main
 
 import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/go-ldap/ldap/v3"
-	"k8s.io/utils/env"
+	"github.com/pkg/errors"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// defaultLdapPageSize is kept below the default AD MaxPageSize (1000).
+	defaultLdapPageSize = 500
+	defaultLdapTimeout  = 30 * time.Second
+
+	ldapMembershipTypeUID = "uid"
+	ldapMembershipTypeDN  = "dn"
+	// ldapMembershipTypeMemberOf derives membership from users' memberOf attribute.
+	ldapMembershipTypeMemberOf = "member_of"
+
+	defaultLdapMemberOfAttributeType = "memberOf"
+
+	ldapScopeBase = "base"
+	ldapScopeOne  = "one"
+	ldapScopeSub  = "sub"
+
+	ldapNestedGroupsRecursive = "recursive"
+	ldapNestedGroupsInChain   = "in_chain"
+
+	ldapUserAccountControlAttributeType = "userAccountControl"
+	// ldapAccountDisableFlag is ACCOUNTDISABLE flag of AD userAccountControl attribute.
+	ldapAccountDisableFlag = 0x2
+
+	// ldapObjectGUIDAttributeType is AD binary object identifier, it is converted to the canonical string form.
+	ldapObjectGUIDAttributeType = "objectGUID"
+
+	// ldapMatchingRuleInChain is AD LDAP_MATCHING_RULE_IN_CHAIN which walks the chain of ancestry in objects.
+	ldapMatchingRuleInChain = "1.2.840.113556.1.4.1941"
+)
+
+var (
+	ldapScopes = map[string]int{
+		ldapScopeBase: ldap.ScopeBaseObject,
+		ldapScopeOne:  ldap.ScopeSingleLevel,
+		ldapScopeSub:  ldap.ScopeWholeSubtree,
+	}
+	// uniqueMember values may have optional uid suffix, e.g. `uid=alice,ou=People,dc=example,dc=org#'0101'B`.
+	ldapUniqueMemberUIDSuffix = regexp.MustCompile(`#'[01]*'B$`)
 )
 
 type Ldap struct {
-	connection *ldap.Conn
+	connection *ldapConnection
 	config     *LdapConfig
 	logger     appLoggerType
+	clock      clock.PassiveClock
+
+	// usersSnapshot and groupsSnapshot cache entries for incremental sync.
+	usersSnapshot  *ldapSnapshot
+	groupsSnapshot *ldapSnapshot
+
+	// userIDsByDN maps normalized user entry DN to user ID, it is filled by the last GetUsers call
+	// and used for resolving DN-based group membership.
+	userIDsByDN map[string]ObjectID
+	// memberIDsByGroupDN maps normalized group DN to IDs of users having it in MemberOfAttributeType,
+	// it is filled by the last GetUsers call for `member_of` membership.
+	memberIDsByGroupDN map[string][]ObjectID
+	// userIDsByUID and groupIDsByName map legacy IDs to the IDs from the configured ID attributes,
+	// they are filled by the last GetUsers and GetGroupsWithMembers calls. They are used for resolving
+	// `uid` membership and for matching records stored before the ID attribute was configured.
+	userIDsByUID   map[string]ObjectID
+	groupIDsByName map[string]ObjectID
+
+	// sampleSize limits the number of entries returned by each search, results are partial then.
+	// It is used by the discover command only, sync never limits searches.
+	sampleSize int
 }
 
+// NewLdap doesn't connect to the server, connection is established on the first request.
 func NewLdap(cfg *LdapConfig, logger appLoggerType) (*Ldap, error) {
-	conn, err := ldap.DialURL(cfg.Address)
-	if err != nil {
-		logger.Fatalf("Failed to connect: %s\n", err)
+	if err := applyLdapPreset(cfg); err != nil {
 		return nil, err
 	}
+	if cfg.PageSize == 0 {
+		cfg.PageSize = defaultLdapPageSize
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultLdapTimeout
+	}
+	if cfg.Groups.MembershipType == "" {
+		cfg.Groups.MembershipType = ldapMembershipTypeUID
+	}
+	switch cfg.Groups.MembershipType {
+	case ldapMembershipTypeUID, ldapMembershipTypeDN:
+	case ldapMembershipTypeMemberOf:
+		if cfg.Users.MemberOfAttributeType == "" {
+			cfg.Users.MemberOfAttributeType = defaultLdapMemberOfAttributeType
+		}
+	default:
+		return nil, errors.Errorf("unknown groups membership_type %q", cfg.Groups.MembershipType)
+	}
+	if cfg.Users.DisabledAttributeType != "" && cfg.Users.DisabledAttributeValue == "" &&
+		!strings.EqualFold(cfg.Users.DisabledAttributeType, ldapUserAccountControlAttributeType) {
+		return nil, errors.Errorf("users disabled_attribute_value should be specified for %s", cfg.Users.DisabledAttributeType)
+	}
+	for _, bases := range []*[]LdapSearchBase{&cfg.Users.SearchBases, &cfg.Groups.SearchBases} {
+		if len(*bases) == 0 {
+			*bases = []LdapSearchBase{{BaseDN: cfg.BaseDN}}
+		}
+		for i := range *bases {
+			base := &(*bases)[i]
+			if base.Scope == "" {
+				base.Scope = ldapScopeSub
+			}
+			if _, ok := ldapScopes[base.Scope]; !ok {
+				return nil, errors.Errorf("unknown scope %q of search base %s", base.Scope, base.BaseDN)
+			}
+		}
+	}
+	if cfg.Incremental != nil {
+		if cfg.Incremental.ChangeAttributeType == "" {
+			cfg.Incremental.ChangeAttributeType = defaultLdapChangeAttributeType
+		}
+		if cfg.Incremental.FullSyncInterval == 0 {
+			cfg.Incremental.FullSyncInterval = defaultLdapFullSyncInterval
+		}
+	}
+	switch cfg.Groups.NestedGroups {
+	case "":
+	case ldapNestedGroupsRecursive, ldapNestedGroupsInChain:
+		if cfg.Groups.MembershipType != ldapMembershipTypeDN {
+			return nil, errors.Errorf("groups nested_groups requires %q membership_type", ldapMembershipTypeDN)
+		}
+	default:
+		return nil, errors.Errorf("unknown groups nested_groups %q", cfg.Groups.NestedGroups)
+	}
 
-	_, err = conn.SimpleBind(&ldap.SimpleBindRequest{
-		Username: cfg.BindDN,
-		Password: env.GetString(cfg.BindPasswordEnvVar, "adminpassword"),
-	})
+	connection, err := newLdapConnection(cfg, logger)
 	if err != nil {
-		logger.Fatalf("Failed to bind: %s\n", err)
 		return nil, err
 	}
 	return &Ldap{
-		connection: conn,
-		config:     cfg,
-		logger:     logger,
+		connection:     connection,
+		config:         cfg,
+		logger:         logger,
+		clock:          clock.RealClock{},
+		usersSnapshot:  &ldapSnapshot{},
+		groupsSnapshot: &ldapSnapshot{},
 	}, nil
 }
 
 func (l *Ldap) CreateUserFromRaw(raw map[string]any) (SourceUser, error) {
-	return NewLdapUser(raw)
+	user, err := NewLdapUser(raw)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == "" && l.config.Users.IDAttributeType != "" {
+		// Record was stored before the ID attribute was configured, it is matched by uid once
+		// and then updated with the ID.
+		user.ID = l.userIDsByUID[user.UID]
+	}
+	return user, nil
 }
 
 func (l *Ldap) CreateGroupFromRaw(raw map[string]any) (SourceGroup, error) {
-	return NewLdapGroup(raw)
+	group, err := NewLdapGroup(raw)
+	if err != nil {
+		return nil, err
+	}
+	if group.ID == "" && l.config.Groups.IDAttributeType != "" {
+		group.ID = l.groupIDsByName[group.Groupname]
+	}
+	return group, nil
 }
 
-func (l *Ldap) GetUsers() ([]SourceUser, error) {
-	res, err := l.connection.Search(&ldap.SearchRequest{
-		BaseDN:     l.config.BaseDN,
-		Filter:     l.config.Users.Filter,
-		Attributes: []string{"*"},
-		Scope:      ldap.ScopeWholeSubtree,
+// search fetches all entries matching the filter using simple paged results control (RFC 2696).
+// Partial results are never returned: entries missing from the result would be treated as removed.
+func (l *Ldap) search(baseDN string, scope int, filter string) ([]*ldap.Entry, error) {
+	return l.searchWithAttributes(baseDN, scope, filter, l.searchAttributes())
+}
+
+func (l *Ldap) searchWithAttributes(baseDN string, scope int, filter string, attributes []string) ([]*ldap.Entry, error) {
+	var res *ldap.SearchResult
+	err := l.connection.do(func(conn *ldap.Conn) error {
+		var err error
+		// Request is created for each attempt, since paging control keeps the cookie of the previous one.
+		request := &ldap.SearchRequest{
+			BaseDN:     baseDN,
+			Filter:     filter,
+			Attributes: attributes,
+			Scope:      scope,
+		}
+		if l.sampleSize > 0 {
+			request.SizeLimit = l.sampleSize
+			res, err = conn.Search(request)
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+				return nil
+			}
+			return err
+		}
+		res, err = conn.SearchWithPaging(request, l.config.PageSize)
+		return err
 	})
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, errors.Wrapf(err, "size limit exceeded while searching %s, page_size (%d) should be lower than the server limit", filter, l.config.PageSize)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to search %s", filter)
+	}
+	return res.Entries, nil
+}
+
+// searchAttributes returns all user attributes, ID and memberOf attributes, which may be operational
+// (e.g. entryUUID, or memberOf maintained by OpenLDAP overlay) and are not returned unless requested explicitly.
+func (l *Ldap) searchAttributes() []string {
+	attributes := []string{"*"}
+	attributeTypes := []string{l.config.Users.IDAttributeType, l.config.Groups.IDAttributeType, l.config.Users.MemberOfAttributeType}
+	if l.config.Incremental != nil {
+		attributeTypes = append(attributeTypes, l.config.Incremental.ChangeAttributeType)
+	}
+	for _, attributeType := range attributeTypes {
+		if attributeType != "" {
+			attributes = append(attributes, attributeType)
+		}
+	}
+	return attributes
+}
+
+// searchBases searches all the bases with the filter and merges results.
+// Entries found in several overlapping bases are returned once.
+func (l *Ldap) searchBases(bases []LdapSearchBase, filter string) ([]*ldap.Entry, error) {
+	return l.searchBasesWithAttributes(bases, filter, l.searchAttributes())
+}
+
+func (l *Ldap) searchBasesWithAttributes(bases []LdapSearchBase, filter string, attributes []string) ([]*ldap.Entry, error) {
+	var entries []*ldap.Entry
+	seenDNs := make(map[string]bool)
+	for _, base := range bases {
+		baseEntries, err := l.searchWithAttributes(base.BaseDN, ldapScopes[base.Scope], filter, attributes)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range baseEntries {
+			normalizedDN := normalizeLdapDN(entry.DN)
+			if seenDNs[normalizedDN] {
+				continue
+			}
+			seenDNs[normalizedDN] = true
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (l *Ldap) GetUsers() ([]SourceUser, error) {
+	entries, err := l.searchEntries(l.usersSnapshot, l.config.Users.SearchBases, l.config.Users.Filter)
 	if err != nil {
 		return nil, err
 	}
 
 	var users []SourceUser
-	for _, entry := range res.Entries {
+	userIDsByDN := make(map[string]ObjectID)
+	userIDsByUID := make(map[string]ObjectID)
+	memberIDsByGroupDN := make(map[string][]ObjectID)
+	seenIDs := make(map[ObjectID]bool)
+	for _, entry := range entries {
 		username := entry.GetAttributeValue(l.config.Users.UsernameAttributeType)
 		uid := entry.GetAttributeValue(l.config.Users.UIDAttributeType)
 		var firstName string
 		if l.config.Users.FirstNameAttributeType != nil {
 			firstName = entry.GetAttributeValue(*l.config.Users.FirstNameAttributeType)
 		}
-		users = append(users, LdapUser{
-			Username:  username,
-			UID:       uid,
-			FirstName: firstName})
+		disabled, err := l.isUserDisabled(entry)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check if user %s is disabled", username)
+		}
+		id, err := getLdapID(entry, l.config.Users.IDAttributeType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get id of user %s", username)
+		}
+		user := LdapUser{
+			ID:         id,
+			Username:   username,
+			UID:        uid,
+			FirstName:  firstName,
+			Attributes: getLdapAttributes(entry, l.config.Users.Attributes),
+			Disabled:   disabled,
+		}
+		// The same ID may be found in several search bases, the first entry wins.
+		if seenIDs[user.GetID()] {
+			l.logger.Warnw("Skipping user with duplicate id", "id", user.GetID(), "dn", entry.DN)
+			continue
+		}
+		seenIDs[user.GetID()] = true
+		users = append(users, user)
+		userIDsByDN[normalizeLdapDN(entry.DN)] = user.GetID()
+		userIDsByUID[uid] = user.GetID()
+		if l.config.Groups.MembershipType == ldapMembershipTypeMemberOf {
+			groupDNs, err := l.getAllAttributeValues(entry, l.config.Users.MemberOfAttributeType)
+			if err != nil {
+				return nil, err
+			}
+			for _, groupDN := range groupDNs {
+				normalizedDN := normalizeLdapDN(groupDN)
+				memberIDsByGroupDN[normalizedDN] = append(memberIDsByGroupDN[normalizedDN], user.GetID())
+			}
+		}
 	}
+	l.userIDsByDN = userIDsByDN
+	l.userIDsByUID = userIDsByUID
+	l.memberIDsByGroupDN = memberIDsByGroupDN
 	return users, nil
 }
 
+func (l *Ldap) isUserDisabled(entry *ldap.Entry) (bool, error) {
+	attributeType := l.config.Users.DisabledAttributeType
+	if attributeType == "" {
+		return false, nil
+	}
+	value := entry.GetEqualFoldAttributeValue(attributeType)
+	if l.config.Users.DisabledAttributeValue != "" {
+		return strings.EqualFold(value, l.config.Users.DisabledAttributeValue), nil
+	}
+	if value == "" {
+		return false, nil
+	}
+	flags, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to parse %s", attributeType)
+	}
+	return flags&ldapAccountDisableFlag != 0, nil
+}
+
 func (l *Ldap) GetGroupsWithMembers() ([]SourceGroupWithMembers, error) {
-	res, err := l.connection.Search(&ldap.SearchRequest{
-		BaseDN:     l.config.BaseDN,
-		Filter:     l.config.Groups.Filter,
-		Attributes: []string{"*"},
-		Scope:      ldap.ScopeWholeSubtree,
-	})
+	entries, err := l.searchEntries(l.groupsSnapshot, l.config.Groups.SearchBases, l.config.Groups.Filter)
 	if err != nil {
 		return nil, err
 	}
 
+	usersRequired := l.config.Groups.MembershipType != ldapMembershipTypeUID || l.config.Users.IDAttributeType != ""
+	if usersRequired && l.userIDsByDN == nil {
+		// Normally users are fetched right before groups in the same sync cycle.
+		if _, err = l.GetUsers(); err != nil {
+			return nil, errors.Wrap(err, "failed to get users for resolving group members")
+		}
+	}
+
+	// nestedGroups caches member DNs of entries by normalized DN for recursive expansion,
+	// entries which are not groups have no members.
+	nestedGroups := make(map[string][]string)
+	if l.config.Groups.NestedGroups == ldapNestedGroupsRecursive {
+		for _, entry := range entries {
+			nestedGroups[normalizeLdapDN(entry.DN)], err = l.getMemberDNs(entry)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	var groups []SourceGroupWithMembers
-	for _, entry := range res.Entries {
+	groupIDsByName := make(map[string]ObjectID)
+	seenIDs := make(map[ObjectID]bool)
+	for _, entry := range entries {
 		groupname := entry.GetAttributeValue(l.config.Groups.GroupnameAttributeType)
-		members := entry.GetAttributeValues(l.config.Groups.MemberUIDAttributeType)
+		id, err := getLdapID(entry, l.config.Groups.IDAttributeType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get id of group %s", groupname)
+		}
+		group := LdapGroup{
+			ID:         id,
+			Groupname:  groupname,
+			Attributes: getLdapAttributes(entry, l.config.Groups.Attributes),
+		}
+		// The same ID may be found in several search bases, the first entry wins.
+		if seenIDs[group.GetID()] {
+			l.logger.Warnw("Skipping group with duplicate id", "id", group.GetID(), "dn", entry.DN)
+			continue
+		}
+		seenIDs[group.GetID()] = true
+		var members []ObjectID
+		switch {
+		case l.config.Groups.MembershipType == ldapMembershipTypeUID:
+			var memberUIDs []string
+			memberUIDs, err = l.getAllAttributeValues(entry, l.config.Groups.MemberUIDAttributeType)
+			if err != nil {
+				return nil, err
+			}
+			members = l.resolveMemberUIDs(groupname, memberUIDs)
+		case l.config.Groups.MembershipType == ldapMembershipTypeMemberOf:
+			// Groups which users refer to, but which are not found by the groups filter, are skipped here.
+			members = l.memberIDsByGroupDN[normalizeLdapDN(entry.DN)]
+		case l.config.Groups.NestedGroups == ldapNestedGroupsRecursive:
+			memberSet := NewStringSet()
+			err = l.expandNestedGroupMembers(entry.DN, memberSet, nestedGroups, make(map[string]bool))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to expand nested groups of %s", groupname)
+			}
+			members = memberSet.ToSlice()
+		case l.config.Groups.NestedGroups == ldapNestedGroupsInChain:
+			members, err = l.getGroupMembersInChain(entry.DN)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get members of %s", groupname)
+			}
+		default:
+			var memberDNs []string
+			memberDNs, err = l.getMemberDNs(entry)
+			if err != nil {
+				return nil, err
+			}
+			members = l.resolveMemberDNs(groupname, memberDNs)
+		}
 		groups = append(groups, SourceGroupWithMembers{
-			SourceGroup: LdapGroup{
-				Groupname: groupname,
-			},
-			Members: NewStringSetFromItems(members...),
+			SourceGroup: group,
+			Members:     NewStringSetFromItems(members...),
 		})
+		groupIDsByName[groupname] = group.GetID()
 	}
+	l.groupIDsByName = groupIDsByName
 	return groups, nil
 }
+
+// resolveMemberUIDs converts member uids to the IDs of users, it is no-op unless users ID attribute is configured.
+func (l *Ldap) resolveMemberUIDs(groupname string, memberUIDs []string) []ObjectID {
+	if l.config.Users.IDAttributeType == "" {
+		return memberUIDs
+	}
+	var memberIDs []ObjectID
+	for _, memberUID := range memberUIDs {
+		userID, ok := l.userIDsByUID[memberUID]
+		if !ok {
+			l.logger.Debugw("Skipping group member which is not a known user", "group", groupname, "member", memberUID)
+			continue
+		}
+		memberIDs = append(memberIDs, userID)
+	}
+	return memberIDs
+}
+
+// getMemberDNs returns DNs of the group entry members.
+func (l *Ldap) getMemberDNs(entry *ldap.Entry) ([]string, error) {
+	values, err := l.getAllAttributeValues(entry, l.config.Groups.MemberUIDAttributeType)
+	if err != nil {
+		return nil, err
+	}
+	var memberDNs []string
+	for _, memberDN := range values {
+		memberDNs = append(memberDNs, ldapUniqueMemberUIDSuffix.ReplaceAllString(memberDN, ""))
+	}
+	return memberDNs, nil
+}
+
+// getAllAttributeValues returns all values of the attribute. Active Directory returns at most MaxValRange
+// (1500 by default) values of the attribute per request as `member;range=0-1499`,
+// in that case the rest values are fetched by subsequent requests `member;range=1500-*` until the last range,
+// which ends with `*`.
+func (l *Ldap) getAllAttributeValues(entry *ldap.Entry, attributeType string) ([]string, error) {
+	values := entry.GetEqualFoldAttributeValues(attributeType)
+	if len(values) > 0 {
+		return values, nil
+	}
+	for continuation := false; ; continuation = true {
+		rangeValues, next, found, err := getLdapRangedAttributeValues(entry, attributeType)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			if continuation {
+				// Returning partial values would make diff remove the rest members.
+				return nil, errors.Errorf("ranged %s of %s is missing in the response", attributeType, entry.DN)
+			}
+			return values, nil
+		}
+		values = append(values, rangeValues...)
+		if next < 0 {
+			return values, nil
+		}
+		rangedAttributeType := fmt.Sprintf("%s;range=%d-*", attributeType, next)
+		entries, err := l.searchWithAttributes(entry.DN, ldap.ScopeBaseObject, "(objectClass=*)", []string{rangedAttributeType})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s of %s", rangedAttributeType, entry.DN)
+		}
+		if len(entries) == 0 {
+			return nil, errors.Errorf("entry %s is not found while getting %s", entry.DN, rangedAttributeType)
+		}
+		entry = entries[0]
+	}
+}
+
+// getLdapRangedAttributeValues returns values of the ranged attribute `<attributeType>;range=<low>-<high>`
+// and the start of the next range, which is -1 if the range is the last one.
+func getLdapRangedAttributeValues(entry *ldap.Entry, attributeType string) (values []string, next int, found bool, err error) {
+	prefix := strings.ToLower(attributeType) + ";range="
+	for _, attribute := range entry.Attributes {
+		if !strings.HasPrefix(strings.ToLower(attribute.Name), prefix) {
+			continue
+		}
+		_, high, ok := strings.Cut(attribute.Name[len(prefix):], "-")
+		if !ok {
+			return nil, 0, false, errors.Errorf("failed to parse range of %s", attribute.Name)
+		}
+		if high == "*" {
+			return attribute.Values, -1, true, nil
+		}
+		last, err := strconv.Atoi(high)
+		if err != nil {
+			return nil, 0, false, errors.Wrapf(err, "failed to parse range of %s", attribute.Name)
+		}
+		return attribute.Values, last + 1, true, nil
+	}
+	return nil, 0, false, nil
+}
+
+// resolveMemberDNs converts member DNs to the IDs of users, members which are not users are skipped.
+func (l *Ldap) resolveMemberDNs(groupname string, memberDNs []string) []ObjectID {
+	var memberIDs []ObjectID
+	for _, memberDN := range memberDNs {
+		userID, ok := l.userIDsByDN[normalizeLdapDN(memberDN)]
+		if !ok {
+			l.logger.Debugw("Skipping group member which is not a known user", "group", groupname, "member", memberDN)
+			continue
+		}
+		memberIDs = append(memberIDs, userID)
+	}
+	return memberIDs
+}
+
+// expandNestedGroupMembers adds users of the group and all its nested groups to members.
+// Member entries which are neither known users nor known groups are fetched by DN and cached in nestedGroups.
+// Visited groups are skipped, which breaks membership cycles.
+func (l *Ldap) expandNestedGroupMembers(groupDN string, members StringSet, nestedGroups map[string][]string, visited map[string]bool) error {
+	normalizedDN := normalizeLdapDN(groupDN)
+	if visited[normalizedDN] {
+		return nil
+	}
+	visited[normalizedDN] = true
+
+	memberDNs, ok := nestedGroups[normalizedDN]
+	if !ok {
+		entries, err := l.search(groupDN, ldap.ScopeBaseObject, "(objectClass=*)")
+		if err != nil && !ldap.IsErrorWithCode(errors.Cause(err), ldap.LDAPResultNoSuchObject) {
+			return err
+		}
+		if len(entries) > 0 {
+			memberDNs, err = l.getMemberDNs(entries[0])
+			if err != nil {
+				return err
+			}
+		}
+		nestedGroups[normalizedDN] = memberDNs
+	}
+
+	for _, memberDN := range memberDNs {
+		if userID, ok := l.userIDsByDN[normalizeLdapDN(memberDN)]; ok {
+			members.Add(userID)
+			continue
+		}
+		if err := l.expandNestedGroupMembers(memberDN, members, nestedGroups, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getGroupMembersInChain returns IDs of users which are members of the group directly or via nested groups,
+// using AD LDAP_MATCHING_RULE_IN_CHAIN.
+func (l *Ldap) getGroupMembersInChain(groupDN string) ([]ObjectID, error) {
+	filter := fmt.Sprintf("(&%s(memberOf:%s:=%s))", l.config.Users.Filter, ldapMatchingRuleInChain, ldap.EscapeFilter(groupDN))
+	entries, err := l.searchBases(l.config.Users.SearchBases, filter)
+	if err != nil {
+		return nil, err
+	}
+	var memberIDs []ObjectID
+	for _, entry := range entries {
+		if userID, ok := l.userIDsByDN[normalizeLdapDN(entry.DN)]; ok {
+			memberIDs = append(memberIDs, userID)
+		}
+	}
+	return memberIDs, nil
+}
+
+// getLdapID returns the value of the ID attribute of the entry, empty string if the attribute is not configured.
+func getLdapID(entry *ldap.Entry, attributeType string) (ObjectID, error) {
+	if attributeType == "" {
+		return "", nil
+	}
+	if strings.EqualFold(attributeType, ldapObjectGUIDAttributeType) {
+		raw := entry.GetEqualFoldRawAttributeValue(attributeType)
+		if len(raw) == 0 {
+			return "", errors.Errorf("%s is missing", attributeType)
+		}
+		return formatLdapObjectGUID(raw)
+	}
+	id := entry.GetEqualFoldAttributeValue(attributeType)
+	if id == "" {
+		return "", errors.Errorf("%s is missing", attributeType)
+	}
+	return id, nil
+}
+
+// formatLdapObjectGUID converts binary objectGUID to the canonical string form,
+// first three components are stored in little-endian byte order.
+func formatLdapObjectGUID(raw []byte) (string, error) {
+	if len(raw) != 16 {
+		return "", errors.Errorf("objectGUID should be 16 bytes long, got %d", len(raw))
+	}
+	return fmt.Sprintf(
+		"%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(raw[0:4]),
+		binary.LittleEndian.Uint16(raw[4:6]),
+		binary.LittleEndian.Uint16(raw[6:8]),
+		raw[8:10],
+		raw[10:16],
+	), nil
+}
+
+// getLdapAttributes returns values of the mapped attributes of the entry.
+// Missing attributes are skipped, so adding a mapping doesn't change records of entries without the attribute.
+func getLdapAttributes(entry *ldap.Entry, mappings LdapAttributeMappings) map[string]any {
+	if len(mappings) == 0 {
+		return nil
+	}
+	attributes := make(map[string]any)
+	for key, mapping := range mappings {
+		values := entry.GetEqualFoldAttributeValues(mapping.AttributeType)
+		if len(values) == 0 {
+			continue
+		}
+		if mapping.MultiValued {
+			// Servers don't guarantee the order of values.
+			values = slices.Clone(values)
+			slices.Sort(values)
+			attributes[key] = values
+		} else {
+			attributes[key] = values[0]
+		}
+	}
+	if len(attributes) == 0 {
+		return nil
+	}
+	return attributes
+}
+
+// normalizeLdapDN returns canonical form of DN suitable for comparison,
+// since the same DN may differ in attribute types case and spaces.
+func normalizeLdapDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+	return strings.ToLower(parsed.String())
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+	"github.com/pkg/errors"
+)
+
+const (
+	ldapBindMethodSimple       = "simple"
+	ldapBindMethodSASLExternal = "sasl_external"
+	ldapBindMethodSASLGSSAPI   = "sasl_gssapi"
+
+	defaultKrb5ConfFile = "/etc/krb5.conf"
+)
+
+// ldapConnection manages a single connection to one of the configured LDAP servers.
+// Connection is established lazily, and if it is lost, the next request reconnects and binds again,
+// failing over to the other servers if the current one is not available.
+type ldapConnection struct {
+	config *LdapConfig
+	logger appLoggerType
+
+	mu   sync.Mutex
+	conn *ldap.Conn
+	// current is an index of the address the last connection was made to, it is tried first on reconnect.
+	current int
+}
+
+func newLdapConnection(cfg *LdapConfig, logger appLoggerType) (*ldapConnection, error) {
+	if len(cfg.Address) == 0 {
+		return nil, errors.New("at least one ldap address should be specified")
+	}
+	// Validate TLS settings beforehand, so misconfiguration is reported on start rather than on sync.
+	for _, address := range cfg.Address {
+		if _, err := buildLdapTLSConfig(address, cfg.TLS); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateLdapBindConfig(cfg); err != nil {
+		return nil, err
+	}
+	return &ldapConnection{
+		config: cfg,
+		logger: logger,
+	}, nil
+}
+
+// currentAddress returns the address of the server which is used or is tried first.
+func (c *ldapConnection) currentAddress() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.config.Address[c.current]
+}
+
+// get returns an established connection or connects to the first available server.
+func (c *ldapConnection) get() (*ldap.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil && !c.conn.IsClosing() {
+		return c.conn, nil
+	}
+	c.conn = nil
+
+	var err error
+	addresses := c.config.Address
+	for i := range addresses {
+		index := (c.current + i) % len(addresses)
+		var conn *ldap.Conn
+		conn, err = c.connect(addresses[index])
+		if err != nil {
+			c.logger.Warnw("Failed to connect to LDAP server", "address", addresses[index], "error", err)
+			continue
+		}
+		if index != c.current {
+			c.logger.Infow("Failed over to another LDAP server", "address", addresses[index])
+		}
+		c.conn = conn
+		c.current = index
+		return conn, nil
+	}
+	return nil, errors.Wrapf(err, "failed to connect to any of LDAP servers %v", addresses)
+}
+
+func (c *ldapConnection) connect(address string) (*ldap.Conn, error) {
+	conn, err := dialLdap(address, c.config.TLS, c.config.Timeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial")
+	}
+	switch c.config.BindMethod {
+	case ldapBindMethodSASLExternal:
+		err = conn.ExternalBind()
+	case ldapBindMethodSASLGSSAPI:
+		err = c.gssapiBind(conn, address)
+	default:
+		err = c.simpleBind(conn)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to bind")
+	}
+	return conn, nil
+}
+
+func (c *ldapConnection) simpleBind(conn *ldap.Conn) error {
+	// Password is read on every bind, so it can be rotated without restart.
+	password := os.Getenv(c.config.BindPasswordEnvVar)
+	if password == "" {
+		return errors.Errorf("bind password env var %s is empty", c.config.BindPasswordEnvVar)
+	}
+	_, err := conn.SimpleBind(&ldap.SimpleBindRequest{
+		Username: c.config.BindDN,
+		Password: password,
+	})
+	return err
+}
+
+func (c *ldapConnection) gssapiBind(conn *ldap.Conn, address string) error {
+	cfg := c.config.Kerberos
+	// Client is created per bind, so the renewed keytab is picked up on reconnect.
+	client, err := gssapi.NewClientWithKeytab(cfg.Username, cfg.Realm, cfg.KeytabFile, cfg.Krb5ConfFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to create kerberos client")
+	}
+	defer client.Close()
+
+	servicePrincipal := cfg.ServicePrincipal
+	if servicePrincipal == "" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse ldap address %s", address)
+		}
+		servicePrincipal = "ldap/" + u.Hostname()
+	}
+	return conn.GSSAPIBind(client, servicePrincipal, "")
+}
+
+// invalidate drops the connection, so the next get call reconnects.
+// If failover is true, the next server is tried first, since the current one is not healthy.
+func (c *ldapConnection) invalidate(conn *ldap.Conn, failover bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn.Close()
+	if c.conn == conn {
+		c.conn = nil
+		if failover {
+			c.current = (c.current + 1) % len(c.config.Address)
+		}
+	}
+}
+
+// do runs the request with the connection. If the request fails because of connection problems,
+// it is retried once with a new connection, which may be established to another server.
+// Request must be safe to retry from scratch.
+func (c *ldapConnection) do(request func(conn *ldap.Conn) error) error {
+	conn, err := c.get()
+	if err != nil {
+		return err
+	}
+	err = request(conn)
+	if err == nil || !(conn.IsClosing() || isLdapConnectionError(err)) {
+		return err
+	}
+
+	// Connection closed by the server (restart, idle timeout) is reestablished to the same server,
+	// while the server which is busy, unavailable or doesn't respond in time is failed over.
+	failover := !conn.IsClosing()
+	c.logger.Warnw("LDAP request failed because of connection problems, reconnecting", "error", err, "failover", failover)
+	c.invalidate(conn, failover)
+	conn, err = c.get()
+	if err != nil {
+		return err
+	}
+	return request(conn)
+}
+
+func isLdapConnectionError(err error) bool {
+	return ldap.IsErrorAnyOf(err, ldap.ErrorNetwork, ldap.LDAPResultBusy, ldap.LDAPResultUnavailable, ldap.LDAPResultServerDown)
+}
+
+// validateLdapBindConfig checks that credentials for the bind method are configured,
+// there is no default password to fall back to.
+func validateLdapBindConfig(cfg *LdapConfig) error {
+	if cfg.BindMethod == "" {
+		cfg.BindMethod = ldapBindMethodSimple
+	}
+	switch cfg.BindMethod {
+	case ldapBindMethodSimple:
+		if cfg.BindDN == "" || cfg.BindPasswordEnvVar == "" {
+			return errors.New("bind_dn and bind_password_env_var are required for simple bind")
+		}
+		if os.Getenv(cfg.BindPasswordEnvVar) == "" {
+			return errors.Errorf("bind password env var %s is empty", cfg.BindPasswordEnvVar)
+		}
+	case ldapBindMethodSASLExternal:
+		for _, address := range cfg.Address {
+			u, err := url.Parse(address)
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse ldap address %s", address)
+			}
+			// Over unix socket server authenticates the peer process, otherwise TLS client certificate is used.
+			if u.Scheme != "ldapi" && (cfg.TLS == nil || cfg.TLS.CertFile == "") {
+				return errors.Errorf("sasl_external bind requires tls cert_file and key_file for address %s", address)
+			}
+		}
+	case ldapBindMethodSASLGSSAPI:
+		if cfg.Kerberos == nil || cfg.Kerberos.Username == "" || cfg.Kerberos.Realm == "" || cfg.Kerberos.KeytabFile == "" {
+			return errors.New("kerberos username, realm and keytab_file are required for sasl_gssapi bind")
+		}
+		if cfg.Kerberos.Krb5ConfFile == "" {
+			cfg.Kerberos.Krb5ConfFile = defaultKrb5ConfFile
+		}
+		if _, err := os.Stat(cfg.Kerberos.KeytabFile); err != nil {
+			return errors.Wrapf(err, "failed to access kerberos keytab_file %s", cfg.Kerberos.KeytabFile)
+		}
+	default:
+		return errors.Errorf("unknown bind_method %q", cfg.BindMethod)
+	}
+	return nil
+}
+
+// buildLdapTLSConfig builds tls.Config for the address, it returns nil if TLS is not needed.
+func buildLdapTLSConfig(address string, cfg *LdapTLSConfig) (*tls.Config, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse ldap address %s", address)
+	}
+	isLdaps := u.Scheme == "ldaps"
+	if cfg == nil {
+		if isLdaps {
+			return &tls.Config{}, nil
+		}
+		return nil, nil
+	}
+	if isLdaps && cfg.StartTLS {
+		return nil, errors.Errorf("start_tls can't be used with ldaps address %s", address)
+	}
+	if !isLdaps && !cfg.StartTLS {
+		return nil, errors.Errorf("tls settings require either ldaps address or start_tls, got %s", address)
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("tls cert_file and key_file should be specified together")
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			// Address without port.
+			host = u.Host
+		}
+		tlsConfig.ServerName = host
+	}
+	if cfg.CAFile != "" {
+		caBundle, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read tls ca_file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, errors.Errorf("no certificates found in tls ca_file %s", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load tls client certificate %s", cfg.CertFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// dialLdap connects to the address, timeout limits dialing and each request made with the connection.
+// Without request timeout a connection silently dropped by a firewall or NAT would block requests forever.
+func dialLdap(address string, cfg *LdapTLSConfig, timeout time.Duration) (*ldap.Conn, error) {
+	tlsConfig, err := buildLdapTLSConfig(address, cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := []ldap.DialOpt{ldap.DialWithDialer(&net.Dialer{Timeout: timeout})}
+	if tlsConfig != nil {
+		opts = append(opts, ldap.DialWithTLSConfig(tlsConfig))
+	}
+	conn, err := ldap.DialURL(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if cfg != nil && cfg.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "failed to start tls")
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ldapActiveDirectoryCapability is LDAP_CAP_ACTIVE_DIRECTORY_OID advertised in AD root DSE supportedCapabilities.
+	ldapActiveDirectoryCapability = "1.2.840.113556.1.4.800"
+)
+
+var (
+	// ldapRootDSEAttributes are operational attributes of the root DSE describing the server.
+	ldapRootDSEAttributes = []string{
+		"vendorName",
+		"vendorVersion",
+		"namingContexts",
+		"defaultNamingContext",
+		"subschemaSubentry",
+		"supportedLDAPVersion",
+		"supportedSASLMechanisms",
+		"supportedCapabilities",
+	}
+	// ldapDiscoverPresets are presets checked in order if the server is not Active Directory.
+	ldapDiscoverPresets = []string{ldapPresetFreeIPA, ldapPresetOpenLdapPosix, ldapPresetGroupOfNames}
+	// ldapDiscoverSchemaNames are object classes and attribute types relevant for users and groups settings,
+	// those defined in the schema are reported.
+	ldapDiscoverSchemaNames = []string{
+		"posixAccount", "inetOrgPerson", "user", "posixGroup", "groupOfNames", "groupOfUniqueNames", "group", "ipaUserGroup",
+		"memberOf", "entryUUID", "objectGUID", "ipaUniqueID", "nsAccountLock", "userAccountControl", "modifyTimestamp", "uSNChanged",
+	}
+	// ldapSchemaName matches NAME of a schema definition, e.g. `NAME 'cn'` or `NAME ( 'cn' 'commonName' )`.
+	ldapSchemaName = regexp.MustCompile(`NAME\s+(?:'([^']*)'|\(([^)]*)\))`)
+)
+
+// runLdapDiscover loads the ldap section of the config and prints the discovery report.
+func runLdapDiscover(configFilePath string, sampleSize int, out io.Writer) error {
+	cfg, err := loadConfig(configFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load config %s", configFilePath)
+	}
+	if cfg.Ldap == nil {
+		return errors.Errorf("ldap section is missing in config %s", configFilePath)
+	}
+	logger, err := configureLogger(&cfg.Logging)
+	if err != nil {
+		return errors.Wrapf(err, "failed to configure logging %+v", cfg.Logging)
+	}
+	return discoverLdap(cfg.Ldap, sampleSize, logger, out)
+}
+
+// discoverLdap binds with the configured credentials, reads the root DSE and schema, detects the directory
+// schema preset and prints the suggested ldap config along with the users and groups it produces
+// for a few sampled entries. Settings specified in cfg are kept in the suggested config.
+func discoverLdap(cfg *LdapConfig, sampleSize int, logger appLoggerType, out io.Writer) error {
+	suggested, err := cloneLdapConfig(cfg)
+	if err != nil {
+		return err
+	}
+	probeConfig, err := cloneLdapConfig(cfg)
+	if err != nil {
+		return err
+	}
+	probe, err := NewLdap(probeConfig, logger)
+	if err != nil {
+		return err
+	}
+	probe.sampleSize = sampleSize
+
+	rootDSE, err := probe.readRootDSE()
+	if err != nil {
+		return err
+	}
+	if suggested.BaseDN == "" {
+		suggested.BaseDN = rootDSE.GetAttributeValue("defaultNamingContext")
+		if suggested.BaseDN == "" {
+			suggested.BaseDN = rootDSE.GetAttributeValue("namingContexts")
+		}
+	}
+	schema, err := probe.readSchema(rootDSE)
+	if err != nil {
+		// Schema may be unreadable for the bind account, the rest is discovered anyway.
+		logger.Warnw("Failed to read LDAP schema", "error", err)
+	}
+	if suggested.Preset == "" && suggested.Users.Filter == "" && suggested.Groups.Filter == "" {
+		suggested.Preset, err = probe.detectPreset(rootDSE, schema, suggested.BaseDN)
+		if err != nil {
+			return err
+		}
+	}
+	preset := suggested.Preset
+	if err = applyLdapPreset(suggested); err != nil {
+		return err
+	}
+	// Preset is resolved into explicit settings, so they can be adjusted.
+	suggested.Preset = ""
+
+	fmt.Fprintln(out, "# Root DSE:")
+	for _, attributeType := range ldapRootDSEAttributes {
+		if values := rootDSE.GetEqualFoldAttributeValues(attributeType); len(values) > 0 {
+			fmt.Fprintf(out, "#   %s: %s\n", attributeType, strings.Join(values, ", "))
+		}
+	}
+	if schema != nil {
+		var known []string
+		for _, name := range ldapDiscoverSchemaNames {
+			if schema.objectClasses.Contains(strings.ToLower(name)) || schema.attributeTypes.Contains(strings.ToLower(name)) {
+				known = append(known, name)
+			}
+		}
+		fmt.Fprintf(out, "# Schema: %d object classes, %d attribute types, including %s\n",
+			schema.objectClasses.Cardinality(), schema.attributeTypes.Cardinality(), strings.Join(known, ", "))
+	}
+	if preset == "" {
+		preset = "none"
+	}
+	fmt.Fprintf(out, "# Schema preset: %s\n", preset)
+
+	configYAML, err := marshalLdapConfigYAML(suggested)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n# Suggested config:\n%s", configYAML)
+
+	if suggested.Users.Filter == "" || suggested.Groups.Filter == "" {
+		fmt.Fprintln(out, "\n# No preset matches the directory, specify users and groups filters to sample entries.")
+		return nil
+	}
+	previewConfig, err := cloneLdapConfig(suggested)
+	if err != nil {
+		return err
+	}
+	preview, err := NewLdap(previewConfig, logger)
+	if err != nil {
+		return err
+	}
+	preview.sampleSize = sampleSize
+	users, err := preview.GetUsers()
+	if err != nil {
+		return err
+	}
+	groups, err := preview.GetGroupsWithMembers()
+	if err != nil {
+		return err
+	}
+
+	var sampledUsers []map[string]any
+	for _, user := range users {
+		raw, err := user.GetRaw()
+		if err != nil {
+			return err
+		}
+		sampledUsers = append(sampledUsers, map[string]any{"name": user.GetName(), "source": raw, "disabled": user.IsDisabled()})
+	}
+	var sampledGroups []map[string]any
+	for _, group := range groups {
+		raw, err := group.SourceGroup.GetRaw()
+		if err != nil {
+			return err
+		}
+		members := group.Members.ToSlice()
+		slices.Sort(members)
+		sampledGroups = append(sampledGroups, map[string]any{"name": group.SourceGroup.GetName(), "source": raw, "members": members})
+	}
+	samplesYAML, err := yaml.Marshal(map[string]any{"users": sampledUsers, "groups": sampledGroups})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal sampled entries")
+	}
+	fmt.Fprintf(out, "\n# Sampled entries (at most %d per search), members are resolved against sampled users only:\n%s", sampleSize, samplesYAML)
+	return nil
+}
+
+func (l *Ldap) readRootDSE() (*ldap.Entry, error) {
+	entries, err := l.searchWithAttributes("", ldap.ScopeBaseObject, "(objectClass=*)", ldapRootDSEAttributes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read root DSE")
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("root DSE is not returned")
+	}
+	return entries[0], nil
+}
+
+// ldapSchema holds lowercased names of object classes and attribute types defined in the server schema.
+type ldapSchema struct {
+	objectClasses  StringSet
+	attributeTypes StringSet
+}
+
+func (l *Ldap) readSchema(rootDSE *ldap.Entry) (*ldapSchema, error) {
+	subschemaDN := rootDSE.GetAttributeValue("subschemaSubentry")
+	if subschemaDN == "" {
+		return nil, errors.New("root DSE has no subschemaSubentry")
+	}
+	entries, err := l.searchWithAttributes(subschemaDN, ldap.ScopeBaseObject, "(objectClass=*)", []string{"objectClasses", "attributeTypes"})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read schema")
+	}
+	schema := &ldapSchema{objectClasses: NewStringSet(), attributeTypes: NewStringSet()}
+	for _, entry := range entries {
+		for attributeType, names := range map[string]StringSet{"objectClasses": schema.objectClasses, "attributeTypes": schema.attributeTypes} {
+			for _, definition := range entry.GetEqualFoldAttributeValues(attributeType) {
+				for _, name := range parseLdapSchemaNames(definition) {
+					names.Add(strings.ToLower(name))
+				}
+			}
+		}
+	}
+	return schema, nil
+}
+
+// detectPreset returns the preset matching the directory: AD is recognized by its root DSE capability,
+// otherwise the first preset which groups filter finds entries with is chosen.
+// If the schema is unknown, only entries are checked.
+func (l *Ldap) detectPreset(rootDSE *ldap.Entry, schema *ldapSchema, baseDN string) (string, error) {
+	if slices.Contains(rootDSE.GetEqualFoldAttributeValues("supportedCapabilities"), ldapActiveDirectoryCapability) {
+		return ldapPresetActiveDirectory, nil
+	}
+	for _, preset := range ldapDiscoverPresets {
+		groups := ldapPresets[preset].groups
+		if schema != nil && !schema.objectClasses.Contains(strings.ToLower(ldapFilterObjectClass(groups.Filter))) {
+			continue
+		}
+		entries, err := l.searchWithAttributes(baseDN, ldap.ScopeWholeSubtree, groups.Filter, []string{ldapNoAttributes})
+		if err != nil {
+			return "", err
+		}
+		if len(entries) > 0 {
+			return preset, nil
+		}
+	}
+	return "", nil
+}
+
+// parseLdapSchemaNames returns names of the RFC 4512 schema definition.
+func parseLdapSchemaNames(definition string) []string {
+	match := ldapSchemaName.FindStringSubmatch(definition)
+	if match == nil {
+		return nil
+	}
+	if match[1] != "" {
+		return []string{match[1]}
+	}
+	var names []string
+	for _, name := range strings.Fields(match[2]) {
+		names = append(names, strings.Trim(name, "'"))
+	}
+	return names
+}
+
+// ldapFilterObjectClass returns the object class of `(objectClass=<class>)` filter.
+func ldapFilterObjectClass(filter string) string {
+	_, objectClass, _ := strings.Cut(strings.TrimSuffix(filter, ")"), "(objectClass=")
+	return objectClass
+}
+
+func cloneLdapConfig(cfg *LdapConfig) (*LdapConfig, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal ldap config")
+	}
+	clone := &LdapConfig{}
+	if err = yaml.Unmarshal(data, clone); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal ldap config")
+	}
+	return clone, nil
+}
+
+// marshalLdapConfigYAML returns `ldap:` config section with unset settings omitted.
+func marshalLdapConfigYAML(cfg *LdapConfig) ([]byte, error) {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal ldap config")
+	}
+	var section map[string]any
+	if err = yaml.Unmarshal(data, &section); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal ldap config")
+	}
+	return yaml.Marshal(map[string]any{"ldap": pruneEmptyYAMLValues(section)})
+}
+
+// pruneEmptyYAMLValues drops zero scalars, empty lists and maps, so only set values are left.
+func pruneEmptyYAMLValues(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		pruned := make(map[string]any)
+		for key, item := range v {
+			if item = pruneEmptyYAMLValues(item); item != nil {
+				pruned[key] = item
+			}
+		}
+		if len(pruned) == 0 {
+			return nil
+		}
+		return pruned
+	case []any:
+		if len(v) == 0 {
+			return nil
+		}
+		pruned := make([]any, 0, len(v))
+		for _, item := range v {
+			pruned = append(pruned, pruneEmptyYAMLValues(item))
+		}
+		return pruned
+	case string:
+		if v == "" {
+			return nil
+		}
+	case int:
+		if v == 0 {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	}
+	return value
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func addFakeLdapRootDSE(server *fakeLdapServer, attributes map[string][]string) {
+	attributes["objectClass"] = []string{"top"}
+	attributes["namingContexts"] = []string{"dc=example,dc=org"}
+	attributes["subschemaSubentry"] = []string{"cn=Subschema"}
+	server.addEntry("", attributes)
+	server.addEntry("cn=Subschema", map[string][]string{
+		"objectClass": {"top", "subschema"},
+		"objectClasses": {
+			"( 2.5.6.9 NAME 'groupOfNames' SUP top STRUCTURAL MUST ( member $ cn ) )",
+			"( 1.3.6.1.1.1.2.2 NAME 'posixGroup' SUP top STRUCTURAL MUST ( cn $ gidNumber ) )",
+			"( 2.16.840.1.113730.3.2.2 NAME 'inetOrgPerson' SUP organizationalPerson STRUCTURAL )",
+		},
+		"attributeTypes": {
+			"( 1.3.6.1.1.16.4 NAME 'entryUUID' EQUALITY UUIDMatch NO-USER-MODIFICATION USAGE directoryOperation )",
+			"( 2.5.4.3 NAME ( 'cn' 'commonName' ) SUP name )",
+		},
+	})
+}
+
+func TestLdapDiscover(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addFakeLdapRootDSE(server, map[string][]string{"vendorName": {"OpenLDAP"}})
+	var memberDNs []string
+	for i, uid := range []string{"alice", "bob", "carol"} {
+		dn := fmt.Sprintf("uid=%s,ou=People,dc=example,dc=org", uid)
+		server.addEntry(dn, map[string][]string{
+			"objectClass": {"top", "inetOrgPerson"},
+			"uid":         {uid},
+			"givenName":   {strings.ToUpper(uid[:1]) + uid[1:]},
+			"entryUUID":   {fmt.Sprintf("b1b2c3d4-0000-1000-8000-00000000000%d", i)},
+		})
+		memberDNs = append(memberDNs, dn)
+	}
+	// Schema has posixGroup, but there are no such entries, so group_of_names preset is chosen.
+	server.addEntry("cn=devs,ou=Groups,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"devs"},
+		"entryUUID":   {"b1b2c3d4-0000-1000-8000-000000000010"},
+		"member":      memberDNs,
+	})
+
+	t.Setenv("LDAP_PASSWORD", "adminpassword")
+	var out bytes.Buffer
+	err := discoverLdap(&LdapConfig{
+		Address:            LdapAddresses{server.url()},
+		BindDN:             "cn=admin,dc=example,dc=org",
+		BindPasswordEnvVar: "LDAP_PASSWORD",
+	}, 2, getDevelopmentLogger(), &out)
+	require.NoError(t, err)
+	report := out.String()
+	require.Contains(t, report, "#   vendorName: OpenLDAP\n")
+	require.Contains(t, report, "including inetOrgPerson, posixGroup, groupOfNames, entryUUID\n")
+	require.Contains(t, report, "# Schema preset: group_of_names\n")
+
+	configPart, samplesPart, found := strings.Cut(report, "# Sampled entries (at most 2 per search)")
+	require.True(t, found)
+
+	// Suggested config is a valid config with the preset resolved and base DN from the root DSE.
+	_, configYAML, _ := strings.Cut(configPart, "# Suggested config:\n")
+	cfg, err := unmarshallConfig([]byte(configYAML))
+	require.NoError(t, err)
+	require.Equal(t, LdapAddresses{server.url()}, cfg.Ldap.Address)
+	require.Equal(t, "dc=example,dc=org", cfg.Ldap.BaseDN)
+	require.Equal(t, "", cfg.Ldap.Preset)
+	require.Equal(t, "(objectClass=inetOrgPerson)", cfg.Ldap.Users.Filter)
+	require.Equal(t, "entryUUID", cfg.Ldap.Users.IDAttributeType)
+	require.Equal(t, "(objectClass=groupOfNames)", cfg.Ldap.Groups.Filter)
+	require.Equal(t, "member", cfg.Ldap.Groups.MemberUIDAttributeType)
+	require.Equal(t, ldapMembershipTypeDN, cfg.Ldap.Groups.MembershipType)
+	require.Zero(t, cfg.Ldap.Timeout)
+
+	_, samplesYAML, _ := strings.Cut(samplesPart, "\n")
+	var samples struct {
+		Users []struct {
+			Name string `yaml:"name"`
+		} `yaml:"users"`
+		Groups []struct {
+			Name    string   `yaml:"name"`
+			Members []string `yaml:"members"`
+		} `yaml:"groups"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(samplesYAML), &samples))
+	require.Len(t, samples.Users, 2)
+	require.Equal(t, "alice", samples.Users[0].Name)
+	require.Len(t, samples.Groups, 1)
+	require.Equal(t, "devs", samples.Groups[0].Name)
+	// carol is not sampled.
+	require.Equal(t, []string{"b1b2c3d4-0000-1000-8000-000000000000", "b1b2c3d4-0000-1000-8000-000000000001"}, samples.Groups[0].Members)
+}
+
+func TestLdapDiscoverActiveDirectory(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addFakeLdapRootDSE(server, map[string][]string{
+		"defaultNamingContext":  {"DC=example,DC=org"},
+		"supportedCapabilities": {"1.2.840.113556.1.4.800", "1.2.840.113556.1.4.1670"},
+	})
+
+	t.Setenv("LDAP_PASSWORD", "adminpassword")
+	var out bytes.Buffer
+	err := discoverLdap(&LdapConfig{
+		Address:            LdapAddresses{server.url()},
+		BindDN:             "cn=admin,dc=example,dc=org",
+		BindPasswordEnvVar: "LDAP_PASSWORD",
+	}, 2, getDevelopmentLogger(), &out)
+	require.NoError(t, err)
+	report := out.String()
+	require.Contains(t, report, "# Schema preset: active_directory\n")
+	require.Contains(t, report, "base_dn: DC=example,DC=org\n")
+	require.Contains(t, report, "id_attribute_type: objectGUID\n")
+}
+
+func TestParseLdapSchemaNames(t *testing.T) {
+	require.Equal(t, []string{"cn", "commonName"}, parseLdapSchemaNames("( 2.5.4.3 NAME ( 'cn' 'commonName' ) SUP name )"))
+	require.Equal(t, []string{"posixGroup"}, parseLdapSchemaNames("( 1.3.6.1.1.1.2.2 NAME 'posixGroup' SUP top )"))
+	require.Nil(t, parseLdapSchemaNames("( 1.2.3 )"))
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// fakeLdapServer is a minimal in-process LDAP server which supports just enough of the protocol
+// (simple bind, StartTLS, search with paging) to test Ldap source without running a real directory.
+type fakeLdapServer struct {
+	listener net.Listener
+	scheme   string
+
+	// startTLSConfig enables StartTLS extended operation if not nil.
+	startTLSConfig *tls.Config
+	bindDN         string
+	bindPassword   string
+	// sizeLimit emulates server-side size limit (like MaxPageSize in AD), zero means no limit.
+	sizeLimit int
+	// maxValRange emulates AD MaxValRange: attributes with more values are returned by ranges
+	// (`member;range=0-1499`), zero means no limit.
+	maxValRange int
+
+	mu       sync.Mutex
+	entries  []*ldap.Entry
+	searches []fakeLdapSearch
+	conns    []net.Conn
+	// unresponsive makes server read requests without answering them and without closing connections,
+	// like a server behind a firewall which silently drops the TCP session.
+	unresponsive bool
+	// searchResultCode makes server answer all searches with the code if it is not zero.
+	searchResultCode int
+}
+
+type fakeLdapSearch struct {
+	BaseDN   string
+	Filter   string
+	PageSize uint32
+}
+
+func newFakeLdapServer(t *testing.T) *fakeLdapServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return startFakeLdapServer(t, listener, "ldap")
+}
+
+// newFakeLdapsServer starts server which accepts only TLS connections (ldaps://).
+func newFakeLdapsServer(t *testing.T, tlsConfig *tls.Config) *fakeLdapServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return startFakeLdapServer(t, tls.NewListener(listener, tlsConfig), "ldaps")
+}
+
+func startFakeLdapServer(t *testing.T, listener net.Listener, scheme string) *fakeLdapServer {
+	s := &fakeLdapServer{
+		listener:     listener,
+		scheme:       scheme,
+		bindDN:       "cn=admin,dc=example,dc=org",
+		bindPassword: "adminpassword",
+	}
+	go s.serve()
+	t.Cleanup(s.close)
+	return s
+}
+
+func (s *fakeLdapServer) url() string {
+	return s.scheme + "://" + s.listener.Addr().String()
+}
+
+func (s *fakeLdapServer) addEntry(dn string, attributes map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, ldap.NewEntry(dn, attributes))
+}
+
+func (s *fakeLdapServer) removeEntry(dn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = slices.DeleteFunc(s.entries, func(entry *ldap.Entry) bool {
+		return normalizeLdapDN(entry.DN) == normalizeLdapDN(dn)
+	})
+}
+
+func (s *fakeLdapServer) getSearches() []fakeLdapSearch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeLdapSearch(nil), s.searches...)
+}
+
+func (s *fakeLdapServer) setUnresponsive(unresponsive bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unresponsive = unresponsive
+}
+
+func (s *fakeLdapServer) isUnresponsive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unresponsive
+}
+
+func (s *fakeLdapServer) setSearchResultCode(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.searchResultCode = code
+}
+
+// dropConnections closes all accepted connections emulating server restart or idle timeout.
+func (s *fakeLdapServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeLdapServer) close() {
+	_ = s.listener.Close()
+	s.dropConnections()
+}
+
+func (s *fakeLdapServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLdapServer) handle(conn net.Conn) {
+	defer conn.Close()
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		if s.isUnresponsive() {
+			continue
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+		var controls []*ber.Packet
+		if len(packet.Children) > 2 {
+			controls = packet.Children[2].Children
+		}
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			bound, err = s.handleBind(conn, messageID, request)
+		case ldap.ApplicationSearchRequest:
+			if !bound {
+				done := newLdapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights, "bind required")
+				err = writeLdapPacket(conn, newLdapResponse(messageID, done))
+				break
+			}
+			err = s.handleSearch(conn, messageID, request, controls)
+		case ldap.ApplicationExtendedRequest:
+			var upgraded net.Conn
+			upgraded, err = s.handleExtended(conn, messageID, request)
+			if upgraded != nil {
+				conn = upgraded
+			}
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeLdapServer) handleBind(conn net.Conn, messageID int64, request *ber.Packet) (bool, error) {
+	name := ber.DecodeString(request.Children[1].Data.Bytes())
+	authentication := request.Children[2]
+	code := ldap.LDAPResultSuccess
+	if authentication.Tag == 3 {
+		// SASL bind, only EXTERNAL mechanism with TLS client certificate is supported.
+		mechanism := ber.DecodeString(authentication.Children[0].Data.Bytes())
+		tlsConn, isTLS := conn.(*tls.Conn)
+		if mechanism != "EXTERNAL" || !isTLS || len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+			code = ldap.LDAPResultInvalidCredentials
+		}
+	} else if name != s.bindDN || ber.DecodeString(authentication.Data.Bytes()) != s.bindPassword {
+		code = ldap.LDAPResultInvalidCredentials
+	}
+	response := newLdapResponse(messageID, newLdapResult(ldap.ApplicationBindResponse, code, ""))
+	return code == ldap.LDAPResultSuccess, writeLdapPacket(conn, response)
+}
+
+func (s *fakeLdapServer) handleExtended(conn net.Conn, messageID int64, request *ber.Packet) (net.Conn, error) {
+	oid := ber.DecodeString(request.Children[0].Data.Bytes())
+	if oid != startTLSOID || s.startTLSConfig == nil {
+		response := newLdapResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError, "unsupported extended operation")
+		return nil, writeLdapPacket(conn, newLdapResponse(messageID, response))
+	}
+	response := newLdapResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess, "")
+	if err := writeLdapPacket(conn, newLdapResponse(messageID, response)); err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Server(conn, s.startTLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+func (s *fakeLdapServer) handleSearch(conn net.Conn, messageID int64, request *ber.Packet, controls []*ber.Packet) error {
+	baseDN := ber.DecodeString(request.Children[0].Data.Bytes())
+	scope := int(request.Children[1].Value.(int64))
+	sizeLimit := int(request.Children[3].Value.(int64))
+	filter := request.Children[6]
+	filterString, err := ldap.DecompileFilter(filter)
+	if err != nil {
+		return err
+	}
+	var attributes []string
+	for _, attribute := range request.Children[7].Children {
+		attributes = append(attributes, ber.DecodeString(attribute.Data.Bytes()))
+	}
+
+	var paging *ldap.ControlPaging
+	for _, control := range controls {
+		decoded, err := ldap.DecodeControl(control)
+		if err != nil {
+			return err
+		}
+		if p, ok := decoded.(*ldap.ControlPaging); ok {
+			paging = p
+		}
+	}
+
+	s.mu.Lock()
+	search := fakeLdapSearch{BaseDN: baseDN, Filter: filterString}
+	if paging != nil {
+		search.PageSize = paging.PagingSize
+	}
+	s.searches = append(s.searches, search)
+	if s.searchResultCode != 0 {
+		s.mu.Unlock()
+		done := newLdapResult(ldap.ApplicationSearchResultDone, s.searchResultCode, "")
+		return writeLdapPacket(conn, newLdapResponse(messageID, done))
+	}
+	var matched []*ldap.Entry
+	baseExists := false
+	for _, entry := range s.entries {
+		if !fakeLdapInScope(baseDN, scope, entry.DN) {
+			continue
+		}
+		baseExists = true
+		if s.match(filter, entry) {
+			matched = append(matched, entry)
+		}
+	}
+	s.mu.Unlock()
+
+	var responseControls []ldap.Control
+	code := ldap.LDAPResultSuccess
+	if scope == ldap.ScopeBaseObject && !baseExists {
+		code = ldap.LDAPResultNoSuchObject
+	}
+	if paging != nil && paging.PagingSize > 0 {
+		offset := 0
+		if len(paging.Cookie) > 0 {
+			offset, err = strconv.Atoi(string(paging.Cookie))
+			if err != nil {
+				return err
+			}
+		}
+		end := min(offset+int(paging.PagingSize), len(matched))
+		responseControl := ldap.NewControlPaging(paging.PagingSize)
+		if end < len(matched) {
+			responseControl.SetCookie([]byte(strconv.Itoa(end)))
+		}
+		responseControls = append(responseControls, responseControl)
+		matched = matched[offset:end]
+	}
+	if s.sizeLimit > 0 && (sizeLimit == 0 || s.sizeLimit < sizeLimit) {
+		sizeLimit = s.sizeLimit
+	}
+	if sizeLimit > 0 && len(matched) > sizeLimit {
+		matched = matched[:sizeLimit]
+		code = ldap.LDAPResultSizeLimitExceeded
+	}
+
+	for _, entry := range matched {
+		entry = s.selectAttributes(entry, attributes)
+		if err := writeLdapPacket(conn, newLdapResponse(messageID, newLdapSearchEntry(entry))); err != nil {
+			return err
+		}
+	}
+	done := newLdapResult(ldap.ApplicationSearchResultDone, code, "")
+	return writeLdapPacket(conn, newLdapResponse(messageID, done, responseControls...))
+}
+
+// selectAttributes returns requested attributes of the entry, splitting values by ranges if maxValRange is set.
+func (s *fakeLdapServer) selectAttributes(entry *ldap.Entry, attributes []string) *ldap.Entry {
+	if s.maxValRange == 0 {
+		return entry
+	}
+	selected := &ldap.Entry{DN: entry.DN}
+	for _, attribute := range entry.Attributes {
+		low, ranged := -1, false
+		for _, requested := range attributes {
+			if requested == "*" || strings.EqualFold(requested, attribute.Name) {
+				low = 0
+			}
+			name, rangeSpec, ok := strings.Cut(requested, ";range=")
+			if ok && strings.EqualFold(name, attribute.Name) {
+				lowString, _, _ := strings.Cut(rangeSpec, "-")
+				low, _ = strconv.Atoi(lowString)
+				ranged = true
+			}
+		}
+		if low < 0 {
+			continue
+		}
+		if !ranged && len(attribute.Values) <= s.maxValRange {
+			selected.Attributes = append(selected.Attributes, attribute)
+			continue
+		}
+		high := min(low+s.maxValRange, len(attribute.Values))
+		name := fmt.Sprintf("%s;range=%d-%d", attribute.Name, low, high-1)
+		if high == len(attribute.Values) {
+			name = fmt.Sprintf("%s;range=%d-*", attribute.Name, low)
+		}
+		selected.Attributes = append(selected.Attributes, ldap.NewEntryAttribute(name, attribute.Values[low:high]))
+	}
+	return selected
+}
+
+func fakeLdapInScope(baseDN string, scope int, dn string) bool {
+	base, err := ldap.ParseDN(baseDN)
+	if err != nil {
+		return false
+	}
+	entry, err := ldap.ParseDN(dn)
+	if err != nil {
+		return false
+	}
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return base.EqualFold(entry)
+	case ldap.ScopeSingleLevel:
+		return len(entry.RDNs) == len(base.RDNs)+1 && base.AncestorOfFold(entry)
+	default:
+		return base.EqualFold(entry) || base.AncestorOfFold(entry)
+	}
+}
+
+func (s *fakeLdapServer) match(filter *ber.Packet, entry *ldap.Entry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !s.match(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if s.match(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !s.match(filter.Children[0], entry)
+	case ldap.FilterExtensibleMatch:
+		var rule, attribute, value string
+		for _, child := range filter.Children {
+			switch child.Tag {
+			case ldap.MatchingRuleAssertionMatchingRule:
+				rule = ber.DecodeString(child.Data.Bytes())
+			case ldap.MatchingRuleAssertionType:
+				attribute = ber.DecodeString(child.Data.Bytes())
+			case ldap.MatchingRuleAssertionMatchValue:
+				value = ber.DecodeString(child.Data.Bytes())
+			}
+		}
+		// Only AD LDAP_MATCHING_RULE_IN_CHAIN for memberOf is supported.
+		if rule != ldapMatchingRuleInChain || !strings.EqualFold(attribute, "memberOf") {
+			return false
+		}
+		return s.isMemberInChain(value, entry.DN, map[string]bool{})
+	case ldap.FilterPresent:
+		return len(entry.GetEqualFoldAttributeValues(ber.DecodeString(filter.Data.Bytes()))) > 0
+	case ldap.FilterGreaterOrEqual:
+		attribute := ber.DecodeString(filter.Children[0].Data.Bytes())
+		value := ber.DecodeString(filter.Children[1].Data.Bytes())
+		for _, v := range entry.GetEqualFoldAttributeValues(attribute) {
+			if v >= value {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterEqualityMatch:
+		attribute := ber.DecodeString(filter.Children[0].Data.Bytes())
+		value := ber.DecodeString(filter.Children[1].Data.Bytes())
+		for _, v := range entry.GetEqualFoldAttributeValues(attribute) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// isMemberInChain checks if dn is a member of the group or any of its nested groups (following `member` attribute).
+func (s *fakeLdapServer) isMemberInChain(groupDN, dn string, visited map[string]bool) bool {
+	if visited[normalizeLdapDN(groupDN)] {
+		return false
+	}
+	visited[normalizeLdapDN(groupDN)] = true
+	for _, group := range s.entries {
+		if normalizeLdapDN(group.DN) != normalizeLdapDN(groupDN) {
+			continue
+		}
+		for _, member := range group.GetEqualFoldAttributeValues("member") {
+			if normalizeLdapDN(member) == normalizeLdapDN(dn) || s.isMemberInChain(member, dn, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newLdapResponse(messageID int64, op *ber.Packet, controls ...ldap.Control) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	packet.AppendChild(op)
+	if len(controls) > 0 {
+		controlsPacket := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, control := range controls {
+			controlsPacket.AppendChild(control.Encode())
+		}
+		packet.AppendChild(controlsPacket)
+	}
+	return packet
+}
+
+func newLdapResult(application ber.Tag, code int, message string) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, application, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	return result
+}
+
+func newLdapSearchEntry(entry *ldap.Entry) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "Object Name"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, attribute := range entry.Attributes {
+		attributePacket := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attributePacket.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attribute.Name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range attribute.ByteValues {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value), "Value"))
+		}
+		attributePacket.AppendChild(values)
+		attributes.AppendChild(attributePacket)
+	}
+	result.AppendChild(attributes)
+	return result
+}
+
+func writeLdapPacket(conn io.Writer, packet *ber.Packet) error {
+	_, err := conn.Write(packet.Bytes())
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	defaultLdapChangeAttributeType = "modifyTimestamp"
+	defaultLdapFullSyncInterval    = 24 * time.Hour
+
+	// ldapNoAttributes is a special attribute list entry which requests entries without attributes (RFC 4511).
+	ldapNoAttributes = "1.1"
+)
+
+// ldapSnapshot is a cache of entries matching the filter, which is updated by incremental syncs.
+type ldapSnapshot struct {
+	// entries are indexed by normalized DN.
+	entries map[string]*ldap.Entry
+	// highWaterMark is the greatest value of the change attribute among the fetched entries.
+	highWaterMark string
+	lastFullSync  time.Time
+	// address of the server the snapshot was fetched from, change values (like uSNChanged)
+	// are specific to the server, so failover requires full sync.
+	address string
+}
+
+// searchEntries returns all entries matching the filter in the bases,
+// with incremental sync enabled only entries changed since the previous call are fetched.
+func (l *Ldap) searchEntries(snapshot *ldapSnapshot, bases []LdapSearchBase, filter string) ([]*ldap.Entry, error) {
+	if l.config.Incremental == nil {
+		return l.searchBases(bases, filter)
+	}
+
+	now := l.clock.Now()
+	address := l.connection.currentAddress()
+	fullSyncRequired := snapshot.entries == nil || snapshot.highWaterMark == "" || snapshot.address != address ||
+		now.Sub(snapshot.lastFullSync) >= l.config.Incremental.FullSyncInterval
+	if fullSyncRequired {
+		l.logger.Infow("Starting full LDAP sync", "filter", filter)
+		entries, err := l.searchBases(bases, filter)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.entries = make(map[string]*ldap.Entry)
+		snapshot.highWaterMark = ""
+		l.applyToSnapshot(snapshot, entries)
+		snapshot.lastFullSync = now
+		snapshot.address = l.connection.currentAddress()
+		return entries, nil
+	}
+
+	// Listing DNs only is cheap, it allows to detect removed entries and entries which don't match the filter anymore.
+	currentEntries, err := l.searchBasesWithAttributes(bases, filter, []string{ldapNoAttributes})
+	if err != nil {
+		return nil, err
+	}
+	changeFilter := fmt.Sprintf("(&%s(%s>=%s))", filter, l.config.Incremental.ChangeAttributeType, ldap.EscapeFilter(snapshot.highWaterMark))
+	changedEntries, err := l.searchBases(bases, changeFilter)
+	if err != nil {
+		return nil, err
+	}
+	if l.connection.currentAddress() != snapshot.address {
+		// Failover happened during the sync.
+		snapshot.entries = nil
+		return l.searchEntries(snapshot, bases, filter)
+	}
+	l.applyToSnapshot(snapshot, changedEntries)
+
+	currentDNs := make(map[string]bool)
+	var entries []*ldap.Entry
+	for _, current := range currentEntries {
+		normalizedDN := normalizeLdapDN(current.DN)
+		currentDNs[normalizedDN] = true
+		entry, ok := snapshot.entries[normalizedDN]
+		if !ok {
+			// Entry matches the filter, though its change value didn't change (e.g. server doesn't
+			// update it on some changes), so it is fetched separately.
+			fetched, err := l.search(current.DN, ldap.ScopeBaseObject, filter)
+			if err != nil {
+				return nil, err
+			}
+			if len(fetched) == 0 {
+				continue
+			}
+			l.applyToSnapshot(snapshot, fetched)
+			entry = fetched[0]
+		}
+		entries = append(entries, entry)
+	}
+	for normalizedDN := range snapshot.entries {
+		if !currentDNs[normalizedDN] {
+			delete(snapshot.entries, normalizedDN)
+		}
+	}
+	l.logger.Infow("Finished incremental LDAP sync",
+		"filter", filter,
+		"changed", len(changedEntries),
+		"total", len(entries),
+	)
+	return entries, nil
+}
+
+func (l *Ldap) applyToSnapshot(snapshot *ldapSnapshot, entries []*ldap.Entry) {
+	for _, entry := range entries {
+		snapshot.entries[normalizeLdapDN(entry.DN)] = entry
+		value := entry.GetEqualFoldAttributeValue(l.config.Incremental.ChangeAttributeType)
+		if value != "" && compareLdapChangeValues(value, snapshot.highWaterMark) > 0 {
+			snapshot.highWaterMark = value
+		}
+	}
+}
+
+// compareLdapChangeValues compares change values numerically (uSNChanged) or as strings (modifyTimestamp).
+func compareLdapChangeValues(a, b string) int {
+	aNumber, aErr := strconv.ParseUint(a, 10, 64)
+	bNumber, bErr := strconv.ParseUint(b, 10, 64)
+	if aErr == nil && bErr == nil {
+		return cmp.Compare(aNumber, bNumber)
+	}
+	return strings.Compare(a, b)
+}
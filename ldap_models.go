@@ -3,10 +3,16 @@ package main
 import "go.ytsaurus.tech/yt/go/yson"
 
 type LdapUser struct {
+	// ID is a value of LdapUsersConfig.IDAttributeType, if it is not configured UID is used as ID.
+	ID        string `yson:"id,omitempty"`
 	Username  string `yson:"username"`
 	UID       string `yson:"uid"`
 	FirstName string `yson:"first_name"`
-	// TODO(nadya73): Add more fields.
+	// Attributes are configured in LdapUsersConfig.Attributes, values are strings or lists of strings.
+	Attributes map[string]any `yson:"attributes,omitempty"`
+
+	// Disabled is not stored in YTsaurus, it is reflected by the user ban.
+	Disabled bool `yson:"-"`
 }
 
 func NewLdapUser(attributes map[string]any) (*LdapUser, error) {
@@ -24,6 +30,9 @@ func NewLdapUser(attributes map[string]any) (*LdapUser, error) {
 }
 
 func (lu LdapUser) GetID() ObjectID {
+	if lu.ID != "" {
+		return lu.ID
+	}
 	return lu.UID
 }
 
@@ -31,6 +40,10 @@ func (lu LdapUser) GetName() string {
 	return lu.Username
 }
 
+func (lu LdapUser) IsDisabled() bool {
+	return lu.Disabled
+}
+
 func (lu LdapUser) GetRaw() (map[string]any, error) {
 	bytes, err := yson.Marshal(lu)
 	if err != nil {
@@ -46,7 +59,11 @@ func (lu LdapUser) GetRaw() (map[string]any, error) {
 }
 
 type LdapGroup struct {
+	// ID is a value of LdapGroupsConfig.IDAttributeType, if it is not configured Groupname is used as ID.
+	ID        string `yson:"id,omitempty"`
 	Groupname string `yson:"groupname"`
+	// Attributes are configured in LdapGroupsConfig.Attributes, values are strings or lists of strings.
+	Attributes map[string]any `yson:"attributes,omitempty"`
 }
 
 func NewLdapGroup(attributes map[string]any) (*LdapGroup, error) {
@@ -64,6 +81,9 @@ func NewLdapGroup(attributes map[string]any) (*LdapGroup, error) {
 }
 
 func (lg LdapGroup) GetID() ObjectID {
+	if lg.ID != "" {
+		return lg.ID
+	}
 	return lg.Groupname
 }
 
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+
+	"go.ytsaurus.tech/library/go/ptr"
+)
+
+const (
+	ldapPresetActiveDirectory = "active_directory"
+	ldapPresetOpenLdapPosix   = "openldap_posix"
+	ldapPresetFreeIPA         = "freeipa"
+	ldapPresetGroupOfNames    = "group_of_names"
+)
+
+// ldapPreset holds defaults for a common directory schema.
+type ldapPreset struct {
+	users  LdapUsersConfig
+	groups LdapGroupsConfig
+	// changeAttributeType is used for incremental sync if it is enabled.
+	changeAttributeType string
+}
+
+var ldapPresets = map[string]ldapPreset{
+	ldapPresetActiveDirectory: {
+		users: LdapUsersConfig{
+			Filter:                 "(&(objectCategory=person)(objectClass=user))",
+			UsernameAttributeType:  "sAMAccountName",
+			UIDAttributeType:       "sAMAccountName",
+			FirstNameAttributeType: ptr.String("givenName"),
+			IDAttributeType:        ldapObjectGUIDAttributeType,
+			DisabledAttributeType:  ldapUserAccountControlAttributeType,
+		},
+		groups: LdapGroupsConfig{
+			Filter:                 "(objectClass=group)",
+			GroupnameAttributeType: "cn",
+			IDAttributeType:        ldapObjectGUIDAttributeType,
+			MemberUIDAttributeType: "member",
+			MembershipType:         ldapMembershipTypeDN,
+		},
+		// uSNChanged is local to the DC, so failover to another DC triggers the full sync.
+		changeAttributeType: "uSNChanged",
+	},
+	ldapPresetOpenLdapPosix: {
+		users: LdapUsersConfig{
+			Filter:                 "(objectClass=posixAccount)",
+			UsernameAttributeType:  "uid",
+			UIDAttributeType:       "uid",
+			FirstNameAttributeType: ptr.String("givenName"),
+			IDAttributeType:        "entryUUID",
+		},
+		groups: LdapGroupsConfig{
+			Filter:                 "(objectClass=posixGroup)",
+			GroupnameAttributeType: "cn",
+			IDAttributeType:        "entryUUID",
+			MemberUIDAttributeType: "memberUid",
+			MembershipType:         ldapMembershipTypeUID,
+		},
+		changeAttributeType: defaultLdapChangeAttributeType,
+	},
+	ldapPresetFreeIPA: {
+		users: LdapUsersConfig{
+			Filter:                 "(objectClass=inetOrgPerson)",
+			UsernameAttributeType:  "uid",
+			UIDAttributeType:       "uid",
+			FirstNameAttributeType: ptr.String("givenName"),
+			IDAttributeType:        "ipaUniqueID",
+			DisabledAttributeType:  "nsAccountLock",
+			DisabledAttributeValue: "TRUE",
+		},
+		groups: LdapGroupsConfig{
+			Filter:                 "(objectClass=ipaUserGroup)",
+			GroupnameAttributeType: "cn",
+			IDAttributeType:        "ipaUniqueID",
+			MemberUIDAttributeType: "member",
+			MembershipType:         ldapMembershipTypeDN,
+			// FreeIPA groups may include other groups.
+			NestedGroups: ldapNestedGroupsRecursive,
+		},
+		changeAttributeType: defaultLdapChangeAttributeType,
+	},
+	ldapPresetGroupOfNames: {
+		users: LdapUsersConfig{
+			Filter:                 "(objectClass=inetOrgPerson)",
+			UsernameAttributeType:  "uid",
+			UIDAttributeType:       "uid",
+			FirstNameAttributeType: ptr.String("givenName"),
+			IDAttributeType:        "entryUUID",
+		},
+		groups: LdapGroupsConfig{
+			Filter:                 "(objectClass=groupOfNames)",
+			GroupnameAttributeType: "cn",
+			IDAttributeType:        "entryUUID",
+			MemberUIDAttributeType: "member",
+			MembershipType:         ldapMembershipTypeDN,
+		},
+		changeAttributeType: defaultLdapChangeAttributeType,
+	},
+}
+
+// applyLdapPreset fills fields which are not specified explicitly with the preset defaults.
+func applyLdapPreset(cfg *LdapConfig) error {
+	if cfg.Preset == "" {
+		return nil
+	}
+	preset, ok := ldapPresets[cfg.Preset]
+	if !ok {
+		return errors.Errorf("unknown ldap preset %q", cfg.Preset)
+	}
+
+	users := &cfg.Users
+	setDefaultString(&users.Filter, preset.users.Filter)
+	setDefaultString(&users.UsernameAttributeType, preset.users.UsernameAttributeType)
+	setDefaultString(&users.UIDAttributeType, preset.users.UIDAttributeType)
+	if users.FirstNameAttributeType == nil {
+		users.FirstNameAttributeType = preset.users.FirstNameAttributeType
+	}
+	setDefaultString(&users.IDAttributeType, preset.users.IDAttributeType)
+	// Disabled value makes sense only with the preset attribute.
+	if users.DisabledAttributeType == "" {
+		users.DisabledAttributeType = preset.users.DisabledAttributeType
+		setDefaultString(&users.DisabledAttributeValue, preset.users.DisabledAttributeValue)
+	}
+
+	groups := &cfg.Groups
+	setDefaultString(&groups.Filter, preset.groups.Filter)
+	setDefaultString(&groups.GroupnameAttributeType, preset.groups.GroupnameAttributeType)
+	setDefaultString(&groups.IDAttributeType, preset.groups.IDAttributeType)
+	// Member attribute and nested groups depend on membership type,
+	// so they are taken from the preset only if membership type is the preset one.
+	setDefaultString(&groups.MembershipType, preset.groups.MembershipType)
+	if groups.MembershipType == preset.groups.MembershipType {
+		setDefaultString(&groups.MemberUIDAttributeType, preset.groups.MemberUIDAttributeType)
+		setDefaultString(&groups.NestedGroups, preset.groups.NestedGroups)
+	}
+
+	if cfg.Incremental != nil {
+		setDefaultString(&cfg.Incremental.ChangeAttributeType, preset.changeAttributeType)
+	}
+	return nil
+}
+
+func setDefaultString(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type ldapPresetFixture struct {
+	entries        map[string]map[string][]string
+	expectedUsers  []SourceUser
+	expectedGroups map[string]StringSet
+}
+
+var ldapPresetFixtures = map[string]ldapPresetFixture{
+	ldapPresetActiveDirectory: {
+		entries: map[string]map[string][]string{
+			"CN=Alice Smith,OU=Staff,DC=example,DC=org": {
+				"objectClass":        {"top", "person", "organizationalPerson", "user"},
+				"objectCategory":     {"person"},
+				"cn":                 {"Alice Smith"},
+				"sAMAccountName":     {"alice"},
+				"givenName":          {"Alice"},
+				"objectGUID":         {string([]byte{0x04, 0x03, 0x02, 0x01, 0x06, 0x05, 0x08, 0x07, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10})},
+				"userAccountControl": {"512"},
+			},
+			"CN=Bob Jones,OU=Staff,DC=example,DC=org": {
+				"objectClass":        {"top", "person", "organizationalPerson", "user"},
+				"objectCategory":     {"person"},
+				"cn":                 {"Bob Jones"},
+				"sAMAccountName":     {"bob"},
+				"givenName":          {"Bob"},
+				"objectGUID":         {string([]byte{0x14, 0x13, 0x12, 0x11, 0x16, 0x15, 0x18, 0x17, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20})},
+				"userAccountControl": {"514"},
+			},
+			// Computer accounts are users too, but they are not persons.
+			"CN=BUILD01,OU=Computers,DC=example,DC=org": {
+				"objectClass":    {"top", "person", "organizationalPerson", "user", "computer"},
+				"objectCategory": {"computer"},
+				"cn":             {"BUILD01"},
+				"sAMAccountName": {"BUILD01$"},
+			},
+			"CN=devs,OU=Groups,DC=example,DC=org": {
+				"objectClass":    {"top", "group"},
+				"cn":             {"devs"},
+				"sAMAccountName": {"devs"},
+				"objectGUID":     {string([]byte{0x24, 0x23, 0x22, 0x21, 0x26, 0x25, 0x28, 0x27, 0x29, 0x2a, 0x2b, 0x2c, 0x2d, 0x2e, 0x2f, 0x30})},
+				"member": {
+					"CN=Alice Smith,OU=Staff,DC=example,DC=org",
+					"CN=Bob Jones,OU=Staff,DC=example,DC=org",
+					"CN=BUILD01,OU=Computers,DC=example,DC=org",
+				},
+			},
+		},
+		expectedUsers: []SourceUser{
+			LdapUser{ID: "01020304-0506-0708-090a-0b0c0d0e0f10", Username: "alice", UID: "alice", FirstName: "Alice"},
+			LdapUser{ID: "11121314-1516-1718-191a-1b1c1d1e1f20", Username: "bob", UID: "bob", FirstName: "Bob", Disabled: true},
+		},
+		expectedGroups: map[string]StringSet{
+			"devs": NewStringSetFromItems("01020304-0506-0708-090a-0b0c0d0e0f10", "11121314-1516-1718-191a-1b1c1d1e1f20"),
+		},
+	},
+	ldapPresetOpenLdapPosix: {
+		entries: map[string]map[string][]string{
+			"uid=alice,ou=People,dc=example,dc=org": {
+				"objectClass": {"top", "posixAccount", "inetOrgPerson"},
+				"uid":         {"alice"},
+				"cn":          {"Alice Smith"},
+				"givenName":   {"Alice"},
+				"entryUUID":   {"a1b2c3d4-0000-1000-8000-000000000001"},
+			},
+			"uid=bob,ou=People,dc=example,dc=org": {
+				"objectClass": {"top", "posixAccount", "inetOrgPerson"},
+				"uid":         {"bob"},
+				"cn":          {"Bob Jones"},
+				"givenName":   {"Bob"},
+				"entryUUID":   {"a1b2c3d4-0000-1000-8000-000000000002"},
+			},
+			"cn=devs,ou=Group,dc=example,dc=org": {
+				"objectClass": {"top", "posixGroup"},
+				"cn":          {"devs"},
+				"entryUUID":   {"a1b2c3d4-0000-1000-8000-000000000003"},
+				"memberUid":   {"alice", "bob", "unknown"},
+			},
+		},
+		expectedUsers: []SourceUser{
+			LdapUser{ID: "a1b2c3d4-0000-1000-8000-000000000001", Username: "alice", UID: "alice", FirstName: "Alice"},
+			LdapUser{ID: "a1b2c3d4-0000-1000-8000-000000000002", Username: "bob", UID: "bob", FirstName: "Bob"},
+		},
+		expectedGroups: map[string]StringSet{
+			"devs": NewStringSetFromItems("a1b2c3d4-0000-1000-8000-000000000001", "a1b2c3d4-0000-1000-8000-000000000002"),
+		},
+	},
+	ldapPresetFreeIPA: {
+		entries: map[string]map[string][]string{
+			"uid=alice,cn=users,cn=accounts,dc=example,dc=org": {
+				"objectClass": {"top", "person", "inetOrgPerson", "posixAccount", "ipaObject"},
+				"uid":         {"alice"},
+				"givenName":   {"Alice"},
+				"ipaUniqueID": {"8a9e5b3c-1111-11ee-a000-000000000001"},
+			},
+			"uid=bob,cn=users,cn=accounts,dc=example,dc=org": {
+				"objectClass":   {"top", "person", "inetOrgPerson", "posixAccount", "ipaObject"},
+				"uid":           {"bob"},
+				"givenName":     {"Bob"},
+				"ipaUniqueID":   {"8a9e5b3c-1111-11ee-a000-000000000002"},
+				"nsAccountLock": {"TRUE"},
+			},
+			"cn=devs,cn=groups,cn=accounts,dc=example,dc=org": {
+				"objectClass": {"top", "groupOfNames", "ipaUserGroup", "ipaObject"},
+				"cn":          {"devs"},
+				"ipaUniqueID": {"8a9e5b3c-1111-11ee-a000-000000000003"},
+				"member": {
+					"uid=alice,cn=users,cn=accounts,dc=example,dc=org",
+					"cn=ops,cn=groups,cn=accounts,dc=example,dc=org",
+				},
+			},
+			"cn=ops,cn=groups,cn=accounts,dc=example,dc=org": {
+				"objectClass": {"top", "groupOfNames", "ipaUserGroup", "ipaObject"},
+				"cn":          {"ops"},
+				"ipaUniqueID": {"8a9e5b3c-1111-11ee-a000-000000000004"},
+				"member":      {"uid=bob,cn=users,cn=accounts,dc=example,dc=org"},
+			},
+		},
+		expectedUsers: []SourceUser{
+			LdapUser{ID: "8a9e5b3c-1111-11ee-a000-000000000001", Username: "alice", UID: "alice", FirstName: "Alice"},
+			LdapUser{ID: "8a9e5b3c-1111-11ee-a000-000000000002", Username: "bob", UID: "bob", FirstName: "Bob", Disabled: true},
+		},
+		expectedGroups: map[string]StringSet{
+			"devs": NewStringSetFromItems("8a9e5b3c-1111-11ee-a000-000000000001", "8a9e5b3c-1111-11ee-a000-000000000002"),
+			"ops":  NewStringSetFromItems("8a9e5b3c-1111-11ee-a000-000000000002"),
+		},
+	},
+	ldapPresetGroupOfNames: {
+		entries: map[string]map[string][]string{
+			"uid=alice,ou=People,dc=example,dc=org": {
+				"objectClass": {"top", "person", "inetOrgPerson"},
+				"uid":         {"alice"},
+				"givenName":   {"Alice"},
+				"entryUUID":   {"b1b2c3d4-0000-1000-8000-000000000001"},
+			},
+			"uid=bob,ou=People,dc=example,dc=org": {
+				"objectClass": {"top", "person", "inetOrgPerson"},
+				"uid":         {"bob"},
+				"givenName":   {"Bob"},
+				"entryUUID":   {"b1b2c3d4-0000-1000-8000-000000000002"},
+			},
+			"cn=devs,ou=Groups,dc=example,dc=org": {
+				"objectClass": {"top", "groupOfNames"},
+				"cn":          {"devs"},
+				"entryUUID":   {"b1b2c3d4-0000-1000-8000-000000000003"},
+				"member":      {"uid=alice,ou=People,dc=example,dc=org"},
+			},
+		},
+		expectedUsers: []SourceUser{
+			LdapUser{ID: "b1b2c3d4-0000-1000-8000-000000000001", Username: "alice", UID: "alice", FirstName: "Alice"},
+			LdapUser{ID: "b1b2c3d4-0000-1000-8000-000000000002", Username: "bob", UID: "bob", FirstName: "Bob"},
+		},
+		expectedGroups: map[string]StringSet{
+			"devs": NewStringSetFromItems("b1b2c3d4-0000-1000-8000-000000000001"),
+		},
+	},
+}
+
+func TestLdapPresets(t *testing.T) {
+	require.Len(t, ldapPresetFixtures, len(ldapPresets))
+	for preset, fixture := range ldapPresetFixtures {
+		t.Run(preset, func(t *testing.T) {
+			server := newFakeLdapServer(t)
+			for dn, attributes := range fixture.entries {
+				server.addEntry(dn, attributes)
+			}
+
+			source := newFakeLdap(t, &LdapConfig{
+				Address:            LdapAddresses{server.url()},
+				BaseDN:             "dc=example,dc=org",
+				BindDN:             "cn=admin,dc=example,dc=org",
+				BindPasswordEnvVar: "LDAP_PASSWORD",
+				Preset:             preset,
+			})
+			users, err := source.GetUsers()
+			require.NoError(t, err)
+			require.ElementsMatch(t, fixture.expectedUsers, users)
+
+			groups, err := source.GetGroupsWithMembers()
+			require.NoError(t, err)
+			members := make(map[string]StringSet)
+			for _, group := range groups {
+				members[group.SourceGroup.GetName()] = group.Members
+			}
+			require.Equal(t, fixture.expectedGroups, members)
+		})
+	}
+}
+
+func TestLdapPresetOverrides(t *testing.T) {
+	cfg := &LdapConfig{
+		Preset:      ldapPresetActiveDirectory,
+		Incremental: &LdapIncrementalConfig{},
+		Users: LdapUsersConfig{
+			Filter:                 "(&(objectCategory=person)(memberOf=CN=sync,DC=example,DC=org))",
+			DisabledAttributeType:  "extensionAttribute1",
+			DisabledAttributeValue: "disabled",
+		},
+		Groups: LdapGroupsConfig{
+			GroupnameAttributeType: "sAMAccountName",
+			MembershipType:         ldapMembershipTypeMemberOf,
+		},
+	}
+	require.NoError(t, applyLdapPreset(cfg))
+
+	require.Equal(t, "(&(objectCategory=person)(memberOf=CN=sync,DC=example,DC=org))", cfg.Users.Filter)
+	require.Equal(t, "sAMAccountName", cfg.Users.UsernameAttributeType)
+	require.Equal(t, "objectGUID", cfg.Users.IDAttributeType)
+	require.Equal(t, "extensionAttribute1", cfg.Users.DisabledAttributeType)
+	require.Equal(t, "disabled", cfg.Users.DisabledAttributeValue)
+
+	require.Equal(t, "(objectClass=group)", cfg.Groups.Filter)
+	require.Equal(t, "sAMAccountName", cfg.Groups.GroupnameAttributeType)
+	require.Equal(t, ldapMembershipTypeMemberOf, cfg.Groups.MembershipType)
+	// Member attribute of the preset is not used with another membership type.
+	require.Equal(t, "", cfg.Groups.MemberUIDAttributeType)
+
+	require.Equal(t, "uSNChanged", cfg.Incremental.ChangeAttributeType)
+
+	cfg = &LdapConfig{Preset: "novell"}
+	require.ErrorContains(t, applyLdapPreset(cfg), `unknown ldap preset "novell"`)
+}
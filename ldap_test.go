@@ -0,0 +1,1050 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+	"go.ytsaurus.tech/library/go/ptr"
+	testclock "k8s.io/utils/clock/testing"
+)
+
+func newFakeLdapConfig(address string) *LdapConfig {
+	return &LdapConfig{
+		Address:            LdapAddresses{address},
+		BaseDN:             "dc=example,dc=org",
+		BindDN:             "cn=admin,dc=example,dc=org",
+		BindPasswordEnvVar: "LDAP_PASSWORD",
+		Users: LdapUsersConfig{
+			Filter:                 "(&(objectClass=posixAccount)(ou=People))",
+			UsernameAttributeType:  "cn",
+			UIDAttributeType:       "uid",
+			FirstNameAttributeType: ptr.String("givenName"),
+		},
+		Groups: LdapGroupsConfig{
+			Filter:                 "(objectClass=posixGroup)",
+			GroupnameAttributeType: "cn",
+			MemberUIDAttributeType: "memberUid",
+		},
+	}
+}
+
+func addFakeLdapUser(server *fakeLdapServer, uid string) {
+	addFakeLdapUserWithAttributes(server, uid, nil)
+}
+
+func addFakeLdapUserWithAttributes(server *fakeLdapServer, uid string, extraAttributes map[string][]string) {
+	attributes := map[string][]string{
+		"objectClass": {"top", "posixAccount", "inetOrgPerson"},
+		"ou":          {"People"},
+		"cn":          {uid + "@acme.com"},
+		"uid":         {uid},
+		"givenName":   {uid},
+	}
+	for attributeType, values := range extraAttributes {
+		attributes[attributeType] = values
+	}
+	server.addEntry(fmt.Sprintf("uid=%s,ou=People,dc=example,dc=org", uid), attributes)
+}
+
+func addFakeLdapGroup(server *fakeLdapServer, name string, memberUIDs ...string) {
+	server.addEntry(fmt.Sprintf("cn=%s,ou=Group,dc=example,dc=org", name), map[string][]string{
+		"objectClass": {"top", "posixGroup"},
+		"cn":          {name},
+		"memberUid":   memberUIDs,
+	})
+}
+
+func newFakeLdap(t *testing.T, cfg *LdapConfig) *Ldap {
+	t.Setenv("LDAP_PASSWORD", "adminpassword")
+	source, err := NewLdap(cfg, getDevelopmentLogger())
+	require.NoError(t, err)
+	return source
+}
+
+func TestLdapPagedSearch(t *testing.T) {
+	server := newFakeLdapServer(t)
+	// Server refuses to return more than 3 entries at once, as AD does with MaxPageSize.
+	server.sizeLimit = 3
+	for i := 0; i < 7; i++ {
+		addFakeLdapUser(server, fmt.Sprintf("user%d", i))
+	}
+	addFakeLdapGroup(server, "devs", "user0", "user6")
+
+	cfg := newFakeLdapConfig(server.url())
+	cfg.PageSize = 2
+	source := newFakeLdap(t, cfg)
+
+	users, err := source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 7)
+
+	groups, err := source.GetGroupsWithMembers()
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	require.Equal(t, NewStringSetFromItems("user0", "user6"), groups[0].Members)
+
+	searches := server.getSearches()
+	// 4 pages for users and 1 page for groups.
+	require.Len(t, searches, 5)
+	for _, search := range searches {
+		require.Equal(t, uint32(2), search.PageSize)
+	}
+}
+
+func TestLdapSizeLimitExceededIsError(t *testing.T) {
+	server := newFakeLdapServer(t)
+	server.sizeLimit = 3
+	for i := 0; i < 7; i++ {
+		addFakeLdapUser(server, fmt.Sprintf("user%d", i))
+	}
+
+	cfg := newFakeLdapConfig(server.url())
+	cfg.PageSize = 5
+	source := newFakeLdap(t, cfg)
+
+	users, err := source.GetUsers()
+	var ldapErr *ldap.Error
+	require.ErrorAs(t, err, &ldapErr)
+	require.Equal(t, uint16(ldap.LDAPResultSizeLimitExceeded), ldapErr.ResultCode)
+	require.Nil(t, users)
+}
+
+type testCertificateAuthority struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	pemPath string
+}
+
+func newTestCertificateAuthority(t *testing.T) *testCertificateAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pemPath := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	return &testCertificateAuthority{cert: cert, key: key, pemPath: pemPath}
+}
+
+func (ca *testCertificateAuthority) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue creates certificate signed by the CA and returns it along with paths to PEM cert and key files.
+func (ca *testCertificateAuthority) issue(t *testing.T, extKeyUsage x509.ExtKeyUsage, dnsNames ...string) (tls.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{extKeyUsage},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, certPEM, 0600))
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	return cert, certPath, keyPath
+}
+
+func TestLdapTLS(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	serverCert, _, _ := ca.issue(t, x509.ExtKeyUsageServerAuth, "ldap.example.org")
+	_, clientCertPath, clientKeyPath := ca.issue(t, x509.ExtKeyUsageClientAuth)
+	otherCA := newTestCertificateAuthority(t)
+
+	serverTLSConfig := &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	mutualTLSConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	}
+
+	for _, tc := range []struct {
+		name          string
+		startTLS      bool
+		serverConfig  *tls.Config
+		tlsConfig     LdapTLSConfig
+		expectedError string
+	}{
+		{
+			name:         "ldaps-ca",
+			serverConfig: serverTLSConfig,
+			tlsConfig:    LdapTLSConfig{CAFile: ca.pemPath},
+		},
+		{
+			name:         "start-tls-ca",
+			startTLS:     true,
+			serverConfig: serverTLSConfig,
+			tlsConfig:    LdapTLSConfig{StartTLS: true, CAFile: ca.pemPath},
+		},
+		{
+			name:         "ldaps-server-name",
+			serverConfig: serverTLSConfig,
+			tlsConfig:    LdapTLSConfig{CAFile: ca.pemPath, ServerName: "ldap.example.org"},
+		},
+		{
+			name:         "start-tls-client-cert",
+			startTLS:     true,
+			serverConfig: mutualTLSConfig,
+			tlsConfig: LdapTLSConfig{
+				StartTLS: true,
+				CAFile:   ca.pemPath,
+				CertFile: clientCertPath,
+				KeyFile:  clientKeyPath,
+			},
+		},
+		{
+			name:          "ldaps-unknown-ca",
+			serverConfig:  serverTLSConfig,
+			tlsConfig:     LdapTLSConfig{CAFile: otherCA.pemPath},
+			expectedError: "certificate signed by unknown authority",
+		},
+		{
+			name:          "start-tls-wrong-server-name",
+			startTLS:      true,
+			serverConfig:  serverTLSConfig,
+			tlsConfig:     LdapTLSConfig{StartTLS: true, CAFile: ca.pemPath, ServerName: "ldap.acme.com"},
+			expectedError: "certificate is valid for ldap.example.org",
+		},
+		{
+			name:         "ldaps-insecure-skip-verify",
+			serverConfig: serverTLSConfig,
+			tlsConfig:    LdapTLSConfig{CAFile: otherCA.pemPath, InsecureSkipVerify: true},
+		},
+		{
+			name:          "start-tls-with-ldaps",
+			serverConfig:  serverTLSConfig,
+			tlsConfig:     LdapTLSConfig{StartTLS: true, CAFile: ca.pemPath},
+			expectedError: "start_tls can't be used with ldaps address",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var server *fakeLdapServer
+			if tc.startTLS {
+				server = newFakeLdapServer(t)
+				server.startTLSConfig = tc.serverConfig
+			} else {
+				server = newFakeLdapsServer(t, tc.serverConfig)
+			}
+			addFakeLdapUser(server, "alice")
+
+			tlsConfig := tc.tlsConfig
+			conn, err := dialLdap(server.url(), &tlsConfig, defaultLdapTimeout)
+			if tc.expectedError != "" {
+				if err == nil {
+					// With TLS 1.3 client certificate or handshake errors may surface on first request.
+					err = conn.Bind(server.bindDN, server.bindPassword)
+				}
+				require.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+			_, isTLS := conn.TLSConnectionState()
+			require.True(t, isTLS)
+
+			cfg := newFakeLdapConfig(server.url())
+			cfg.TLS = &tlsConfig
+			source := newFakeLdap(t, cfg)
+			users, err := source.GetUsers()
+			require.NoError(t, err)
+			require.Len(t, users, 1)
+		})
+	}
+}
+
+func TestLdapReconnect(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addFakeLdapUser(server, "alice")
+	source := newFakeLdap(t, newFakeLdapConfig(server.url()))
+
+	users, err := source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	// Server has closed the connection (restart or idle timeout), app should reconnect and bind again.
+	server.dropConnections()
+	users, err = source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+}
+
+func TestLdapTimeout(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addFakeLdapUser(server, "alice")
+	cfg := newFakeLdapConfig(server.url())
+	cfg.Timeout = 200 * time.Millisecond
+	source := newFakeLdap(t, cfg)
+
+	_, err := source.GetUsers()
+	require.NoError(t, err)
+
+	// Server doesn't respond anymore, but connection isn't closed: request should fail instead of hanging.
+	server.setUnresponsive(true)
+	done := make(chan error)
+	go func() {
+		_, err := source.GetUsers()
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		require.ErrorContains(t, err, "timed out")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "request to unresponsive server hangs")
+	}
+
+	server.setUnresponsive(false)
+	users, err := source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+}
+
+func TestLdapFailover(t *testing.T) {
+	first := newFakeLdapServer(t)
+	second := newFakeLdapServer(t)
+	third := newFakeLdapServer(t)
+	for _, server := range []*fakeLdapServer{first, second, third} {
+		addFakeLdapUser(server, "alice")
+	}
+
+	cfg := newFakeLdapConfig(first.url())
+	cfg.Address = LdapAddresses{first.url(), second.url(), third.url()}
+	cfg.Timeout = 200 * time.Millisecond
+	source := newFakeLdap(t, cfg)
+
+	_, err := source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, first.getSearches(), 1)
+
+	first.close()
+	users, err := source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Len(t, second.getSearches(), 1)
+
+	// Server accepts the bind, but is not able to serve searches: app should fail over to the next one.
+	second.setSearchResultCode(ldap.LDAPResultUnavailable)
+	users, err = source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Len(t, second.getSearches(), 2)
+	require.Len(t, third.getSearches(), 1)
+
+	// Server doesn't respond in time: app should fail over to the next available one.
+	second.setSearchResultCode(0)
+	third.setUnresponsive(true)
+	users, err = source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Len(t, second.getSearches(), 3)
+
+	second.close()
+	third.close()
+	_, err = source.GetUsers()
+	require.ErrorContains(t, err, "failed to connect to any of LDAP servers")
+	_, err = source.GetGroupsWithMembers()
+	require.ErrorContains(t, err, "failed to connect to any of LDAP servers")
+}
+
+func TestLdapBindError(t *testing.T) {
+	server := newFakeLdapServer(t)
+	t.Setenv("LDAP_PASSWORD", "wrong-password")
+
+	source, err := NewLdap(newFakeLdapConfig(server.url()), getDevelopmentLogger())
+	require.NoError(t, err)
+	_, err = source.GetUsers()
+	require.ErrorContains(t, err, "failed to bind")
+}
+
+func TestLdapBindConfig(t *testing.T) {
+	keytabPath := filepath.Join(t.TempDir(), "sync.keytab")
+	require.NoError(t, os.WriteFile(keytabPath, []byte{}, 0600))
+
+	for _, tc := range []struct {
+		name          string
+		modify        func(cfg *LdapConfig)
+		expectedError string
+	}{
+		{
+			name:          "simple-empty-password",
+			modify:        func(cfg *LdapConfig) { cfg.BindPasswordEnvVar = "LDAP_MISSING_PASSWORD" },
+			expectedError: "bind password env var LDAP_MISSING_PASSWORD is empty",
+		},
+		{
+			name:          "simple-no-password-env-var",
+			modify:        func(cfg *LdapConfig) { cfg.BindPasswordEnvVar = "" },
+			expectedError: "bind_dn and bind_password_env_var are required",
+		},
+		{
+			name:          "external-no-client-cert",
+			modify:        func(cfg *LdapConfig) { cfg.BindMethod = ldapBindMethodSASLExternal },
+			expectedError: "sasl_external bind requires tls cert_file and key_file",
+		},
+		{
+			name: "gssapi-no-keytab",
+			modify: func(cfg *LdapConfig) {
+				cfg.BindMethod = ldapBindMethodSASLGSSAPI
+				cfg.Kerberos = &LdapKerberosConfig{Username: "sync", Realm: "ACME.COM"}
+			},
+			expectedError: "kerberos username, realm and keytab_file are required",
+		},
+		{
+			name: "gssapi-missing-keytab",
+			modify: func(cfg *LdapConfig) {
+				cfg.BindMethod = ldapBindMethodSASLGSSAPI
+				cfg.Kerberos = &LdapKerberosConfig{Username: "sync", Realm: "ACME.COM", KeytabFile: keytabPath + ".missing"}
+			},
+			expectedError: "failed to access kerberos keytab_file",
+		},
+		{
+			name: "gssapi",
+			modify: func(cfg *LdapConfig) {
+				cfg.BindMethod = ldapBindMethodSASLGSSAPI
+				cfg.Kerberos = &LdapKerberosConfig{Username: "sync", Realm: "ACME.COM", KeytabFile: keytabPath}
+			},
+		},
+		{
+			name:          "unknown",
+			modify:        func(cfg *LdapConfig) { cfg.BindMethod = "sasl_plain" },
+			expectedError: `unknown bind_method "sasl_plain"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("LDAP_PASSWORD", "adminpassword")
+			cfg := newFakeLdapConfig("ldap://127.0.0.1:389")
+			tc.modify(cfg)
+			_, err := NewLdap(cfg, getDevelopmentLogger())
+			if tc.expectedError != "" {
+				require.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, defaultKrb5ConfFile, cfg.Kerberos.Krb5ConfFile)
+		})
+	}
+}
+
+func TestLdapExternalBind(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	serverCert, _, _ := ca.issue(t, x509.ExtKeyUsageServerAuth)
+	_, clientCertPath, clientKeyPath := ca.issue(t, x509.ExtKeyUsageClientAuth)
+	server := newFakeLdapsServer(t, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    ca.pool(),
+	})
+	addFakeLdapUser(server, "alice")
+
+	// No password is configured, the client certificate is the only credential.
+	cfg := newFakeLdapConfig(server.url())
+	cfg.BindMethod = ldapBindMethodSASLExternal
+	cfg.BindDN = ""
+	cfg.BindPasswordEnvVar = ""
+	cfg.TLS = &LdapTLSConfig{CAFile: ca.pemPath, CertFile: clientCertPath, KeyFile: clientKeyPath}
+	source, err := NewLdap(cfg, getDevelopmentLogger())
+	require.NoError(t, err)
+	users, err := source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+
+	// Without the certificate server rejects EXTERNAL bind.
+	conn, err := dialLdap(server.url(), &LdapTLSConfig{CAFile: ca.pemPath}, defaultLdapTimeout)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.True(t, ldap.IsErrorWithCode(conn.ExternalBind(), ldap.LDAPResultInvalidCredentials))
+}
+
+func TestLdapDNMembership(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addFakeLdapUser(server, "alice")
+	addFakeLdapUser(server, "bob")
+	addFakeLdapUser(server, "carol")
+	server.addEntry("cn=devs,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"devs"},
+		"member": {
+			"uid=alice,ou=People,dc=example,dc=org",
+			// DNs may differ from entry DNs in case and spaces.
+			"UID=bob, OU=People, DC=example, DC=org",
+			// Not a user.
+			"cn=admin,dc=example,dc=org",
+		},
+	})
+	server.addEntry("cn=qa,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass":  {"top", "groupOfUniqueNames"},
+		"cn":           {"qa"},
+		"uniqueMember": {"uid=carol,ou=People,dc=example,dc=org#'0101'B"},
+	})
+
+	for _, tc := range []struct {
+		filter          string
+		memberAttribute string
+		expectedMembers StringSet
+	}{
+		{
+			filter:          "(objectClass=groupOfNames)",
+			memberAttribute: "member",
+			expectedMembers: NewStringSetFromItems("alice", "bob"),
+		},
+		{
+			filter:          "(objectClass=groupOfUniqueNames)",
+			memberAttribute: "uniqueMember",
+			expectedMembers: NewStringSetFromItems("carol"),
+		},
+	} {
+		t.Run(tc.memberAttribute, func(t *testing.T) {
+			cfg := newFakeLdapConfig(server.url())
+			cfg.Groups.Filter = tc.filter
+			cfg.Groups.MemberUIDAttributeType = tc.memberAttribute
+			cfg.Groups.MembershipType = "dn"
+			source := newFakeLdap(t, cfg)
+
+			// Groups may be requested before users, in that case users are fetched implicitly.
+			groups, err := source.GetGroupsWithMembers()
+			require.NoError(t, err)
+			require.Len(t, groups, 1)
+			require.Equal(t, tc.expectedMembers, groups[0].Members)
+		})
+	}
+}
+
+func TestLdapMemberOfMembership(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addFakeLdapUserWithAttributes(server, "alice", map[string][]string{
+		"memberOf": {"cn=devs,ou=Group,dc=example,dc=org", "cn=qa,ou=Group,dc=example,dc=org"},
+	})
+	addFakeLdapUserWithAttributes(server, "bob", map[string][]string{
+		// DNs may differ from entry DNs in case and spaces.
+		"memberOf": {"CN=devs, OU=Group, DC=example, DC=org"},
+	})
+	addFakeLdapUserWithAttributes(server, "carol", map[string][]string{
+		// Group which doesn't match the groups filter.
+		"memberOf": {"cn=admins,ou=Admins,dc=example,dc=org"},
+	})
+	// Group entries have no member lists.
+	for _, name := range []string{"devs", "qa", "empty"} {
+		addFakeLdapGroup(server, name)
+	}
+	server.addEntry("cn=admins,ou=Admins,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"admins"},
+	})
+
+	cfg := newFakeLdapConfig(server.url())
+	cfg.Groups.MembershipType = ldapMembershipTypeMemberOf
+	source := newFakeLdap(t, cfg)
+	require.Equal(t, "memberOf", cfg.Users.MemberOfAttributeType)
+
+	// Groups may be requested before users, in that case users are fetched implicitly.
+	groups, err := source.GetGroupsWithMembers()
+	require.NoError(t, err)
+	members := make(map[string]StringSet)
+	for _, group := range groups {
+		members[group.SourceGroup.GetName()] = group.Members
+	}
+	require.Equal(t, map[string]StringSet{
+		"devs":  NewStringSetFromItems("alice", "bob"),
+		"qa":    NewStringSetFromItems("alice"),
+		"empty": NewStringSetFromItems(),
+	}, members)
+
+	// Membership follows users' memberOf changes.
+	server.removeEntry("uid=bob,ou=People,dc=example,dc=org")
+	addFakeLdapUserWithAttributes(server, "bob", map[string][]string{
+		"memberOf": {"cn=qa,ou=Group,dc=example,dc=org"},
+	})
+	_, err = source.GetUsers()
+	require.NoError(t, err)
+	groups, err = source.GetGroupsWithMembers()
+	require.NoError(t, err)
+	for _, group := range groups {
+		members[group.SourceGroup.GetName()] = group.Members
+	}
+	require.Equal(t, NewStringSetFromItems("alice"), members["devs"])
+	require.Equal(t, NewStringSetFromItems("alice", "bob"), members["qa"])
+}
+
+func TestLdapNestedGroups(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addFakeLdapUser(server, "alice")
+	addFakeLdapUser(server, "bob")
+	addFakeLdapUser(server, "carol")
+	addFakeLdapUser(server, "dave")
+	// all -> backend -> platform -> all is a cycle, platform doesn't match groups filter.
+	server.addEntry("cn=all,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"all"},
+		"member": {
+			"uid=alice,ou=People,dc=example,dc=org",
+			"cn=backend,ou=Group,dc=example,dc=org",
+			// Dangling member.
+			"cn=removed,ou=Group,dc=example,dc=org",
+		},
+	})
+	server.addEntry("cn=backend,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"backend"},
+		"member": {
+			"uid=bob,ou=People,dc=example,dc=org",
+			"CN=platform, OU=Group, DC=example, DC=org",
+		},
+	})
+	server.addEntry("cn=platform,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"platform"},
+		"member": {
+			"uid=carol,ou=People,dc=example,dc=org",
+			"cn=all,ou=Group,dc=example,dc=org",
+		},
+	})
+	server.addEntry("cn=other,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "groupOfNames"},
+		"cn":          {"other"},
+		"member":      {"uid=dave,ou=People,dc=example,dc=org"},
+	})
+
+	for _, nestedGroups := range []string{"recursive", "in_chain"} {
+		t.Run(nestedGroups, func(t *testing.T) {
+			cfg := newFakeLdapConfig(server.url())
+			cfg.Groups.Filter = "(&(objectClass=groupOfNames)(|(cn=all)(cn=backend)))"
+			cfg.Groups.MemberUIDAttributeType = "member"
+			cfg.Groups.MembershipType = "dn"
+			cfg.Groups.NestedGroups = nestedGroups
+			source := newFakeLdap(t, cfg)
+
+			_, err := source.GetUsers()
+			require.NoError(t, err)
+			groups, err := source.GetGroupsWithMembers()
+			require.NoError(t, err)
+
+			members := make(map[string]StringSet)
+			for _, group := range groups {
+				members[group.SourceGroup.GetName()] = group.Members
+			}
+			require.Equal(t, map[string]StringSet{
+				"all":     NewStringSetFromItems("alice", "bob", "carol"),
+				"backend": NewStringSetFromItems("alice", "bob", "carol"),
+			}, members)
+		})
+	}
+}
+
+func TestLdapNestedGroupsRequireDNMembership(t *testing.T) {
+	cfg := newFakeLdapConfig("ldap://localhost:389")
+	cfg.Groups.NestedGroups = "recursive"
+	_, err := NewLdap(cfg, getDevelopmentLogger())
+	require.Error(t, err)
+}
+
+func TestLdapDisabledUsers(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		attributeType  string
+		attributeValue string
+		values         map[string]string
+		expected       map[string]bool
+		expectedError  string
+	}{
+		{
+			name:          "user-account-control",
+			attributeType: "userAccountControl",
+			values:        map[string]string{"alice": "512", "bob": "514"},
+			// Carol doesn't have the attribute at all.
+			expected: map[string]bool{"alice": false, "bob": true, "carol": false},
+		},
+		{
+			name:           "ns-account-lock",
+			attributeType:  "nsAccountLock",
+			attributeValue: "TRUE",
+			values:         map[string]string{"alice": "FALSE", "bob": "true"},
+			expected:       map[string]bool{"alice": false, "bob": true, "carol": false},
+		},
+		{
+			name:          "invalid-user-account-control",
+			attributeType: "userAccountControl",
+			values:        map[string]string{"alice": "disabled"},
+			expectedError: "failed to parse userAccountControl",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newFakeLdapServer(t)
+			for _, uid := range []string{"alice", "bob", "carol"} {
+				var attributes map[string][]string
+				if value, ok := tc.values[uid]; ok {
+					attributes = map[string][]string{tc.attributeType: {value}}
+				}
+				addFakeLdapUserWithAttributes(server, uid, attributes)
+			}
+
+			cfg := newFakeLdapConfig(server.url())
+			cfg.Users.DisabledAttributeType = tc.attributeType
+			cfg.Users.DisabledAttributeValue = tc.attributeValue
+			source := newFakeLdap(t, cfg)
+
+			users, err := source.GetUsers()
+			if tc.expectedError != "" {
+				require.ErrorContains(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			disabled := make(map[string]bool)
+			for _, user := range users {
+				disabled[user.GetID()] = user.IsDisabled()
+			}
+			require.Equal(t, tc.expected, disabled)
+		})
+	}
+}
+
+func TestLdapDisabledAttributeValueRequired(t *testing.T) {
+	cfg := newFakeLdapConfig("ldap://localhost:389")
+	cfg.Users.DisabledAttributeType = "nsAccountLock"
+	_, err := NewLdap(cfg, getDevelopmentLogger())
+	require.Error(t, err)
+}
+
+func TestLdapAttributes(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addFakeLdapUserWithAttributes(server, "alice", map[string][]string{
+		"mail":     {"alice@acme.com"},
+		"memberOf": {"cn=qa,ou=Group,dc=example,dc=org", "cn=devs,ou=Group,dc=example,dc=org"},
+	})
+	// Bob doesn't have mapped attributes.
+	addFakeLdapUser(server, "bob")
+	server.addEntry("cn=devs,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "posixGroup"},
+		"cn":          {"devs"},
+		"description": {"Developers"},
+	})
+
+	cfg := newFakeLdapConfig(server.url())
+	cfg.Users.Attributes = LdapAttributeMappings{
+		"email":  {AttributeType: "mail"},
+		"groups": {AttributeType: "memberOf", MultiValued: true},
+	}
+	cfg.Groups.Attributes = LdapAttributeMappings{
+		"description": {AttributeType: "description"},
+	}
+	source := newFakeLdap(t, cfg)
+
+	users, err := source.GetUsers()
+	require.NoError(t, err)
+	raws := make(map[string]map[string]any)
+	for _, user := range users {
+		raws[user.GetID()], err = user.GetRaw()
+		require.NoError(t, err)
+	}
+	require.Equal(t, map[string]any{
+		"email":  "alice@acme.com",
+		"groups": []any{"cn=devs,ou=Group,dc=example,dc=org", "cn=qa,ou=Group,dc=example,dc=org"},
+	}, raws["alice"]["attributes"])
+	require.Equal(t, map[string]any{
+		"username":   "bob@acme.com",
+		"uid":        "bob",
+		"first_name": "bob",
+	}, raws["bob"])
+
+	// Record stored before the mapping was configured isn't changed if the user has no mapped attributes.
+	app := newDiffTestApp(initialTestTime)
+	newBob, err := app.buildYtsaurusUser(users[1])
+	require.NoError(t, err)
+	changed, _, err := app.isUserChanged(newBob, YtsaurusUser{Username: "bob", SourceRaw: map[string]any{
+		"username":   "bob@acme.com",
+		"uid":        "bob",
+		"first_name": "bob",
+	}})
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	groups, err := source.GetGroupsWithMembers()
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	groupRaw, err := groups[0].SourceGroup.GetRaw()
+	require.NoError(t, err)
+	require.Equal(t, map[string]any{"description": "Developers"}, groupRaw["attributes"])
+}
+
+func TestLdapObjectGUID(t *testing.T) {
+	guid, err := formatLdapObjectGUID([]byte{
+		0x04, 0x03, 0x02, 0x01, 0x06, 0x05, 0x08, 0x07,
+		0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "01020304-0506-0708-090a-0b0c0d0e0f10", guid)
+
+	_, err = formatLdapObjectGUID([]byte{0x01})
+	require.Error(t, err)
+}
+
+func TestLdapStableIDs(t *testing.T) {
+	server := newFakeLdapServer(t)
+	// Alice has been renamed to alicia since the last sync.
+	addFakeLdapUserWithAttributes(server, "alicia", map[string][]string{
+		"objectGUID": {string([]byte{0x04, 0x03, 0x02, 0x01, 0x06, 0x05, 0x08, 0x07, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10})},
+	})
+	addFakeLdapUserWithAttributes(server, "bob", map[string][]string{
+		"objectGUID": {string([]byte{0x14, 0x13, 0x12, 0x11, 0x16, 0x15, 0x18, 0x17, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20})},
+	})
+	server.addEntry("cn=developers,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "posixGroup"},
+		"cn":          {"developers"},
+		"entryUUID":   {"6b4f5c5e-1f1e-103e-8f6e-f5e5e2b1f2a1"},
+		"memberUid":   {"alicia", "bob", "unknown"},
+	})
+
+	cfg := newFakeLdapConfig(server.url())
+	cfg.Users.IDAttributeType = "objectGUID"
+	cfg.Groups.IDAttributeType = "entryUUID"
+	source := newFakeLdap(t, cfg)
+	const aliceID = "01020304-0506-0708-090a-0b0c0d0e0f10"
+	const bobID = "11121314-1516-1718-191a-1b1c1d1e1f20"
+
+	users, err := source.GetUsers()
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+
+	groups, err := source.GetGroupsWithMembers()
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	require.Equal(t, "6b4f5c5e-1f1e-103e-8f6e-f5e5e2b1f2a1", groups[0].SourceGroup.GetID())
+	require.Equal(t, NewStringSetFromItems(aliceID, bobID), groups[0].Members)
+
+	app := newDiffTestApp(initialTestTime)
+	app.source = source
+	app.usernameReplaces = nil
+	diff, err := app.diffUsers(users, []YtsaurusUser{
+		{Username: "alice@acme.com", SourceRaw: map[string]any{
+			"id":         aliceID,
+			"username":   "alice@acme.com",
+			"uid":        "alice",
+			"first_name": "alice",
+		}},
+		// Bob was stored before the ID attribute was configured.
+		{Username: "bob@acme.com", SourceRaw: map[string]any{
+			"username":   "bob@acme.com",
+			"uid":        "bob",
+			"first_name": "bob",
+		}},
+	})
+	require.NoError(t, err)
+	require.Empty(t, diff.create)
+	require.Empty(t, diff.remove)
+	require.Len(t, diff.update, 2)
+	updatedUsernames := make(map[string]string)
+	for _, user := range diff.update {
+		updatedUsernames[user.OldUsername] = user.Username
+		require.NotEmpty(t, user.SourceRaw["id"])
+	}
+	require.Equal(t, map[string]string{
+		"alice@acme.com": "alicia@acme.com",
+		"bob@acme.com":   "bob@acme.com",
+	}, updatedUsernames)
+}
+
+func TestLdapSearchBases(t *testing.T) {
+	server := newFakeLdapServer(t)
+	for _, user := range []struct{ uid, ou string }{
+		{uid: "alice", ou: "ou=People"},
+		{uid: "bob", ou: "ou=Contractors"},
+		// Deeper than `one` scope of ou=People.
+		{uid: "carol", ou: "ou=Archive,ou=People"},
+		// Same uid in another base.
+		{uid: "alice", ou: "ou=Contractors"},
+	} {
+		uid := user.uid
+		server.addEntry(fmt.Sprintf("uid=%s,%s,dc=example,dc=org", uid, user.ou), map[string][]string{
+			"objectClass": {"top", "posixAccount"},
+			"cn":          {uid + "@acme.com"},
+			"uid":         {uid},
+		})
+	}
+	addFakeLdapGroup(server, "devs", "alice", "bob", "carol")
+	server.addEntry("cn=qa,ou=Teams,dc=other,dc=org", map[string][]string{
+		"objectClass": {"top", "posixGroup"},
+		"cn":          {"qa"},
+		"memberUid":   {"bob"},
+	})
+
+	cfg := newFakeLdapConfig(server.url())
+	cfg.Users.Filter = "(objectClass=posixAccount)"
+	cfg.Users.SearchBases = []LdapSearchBase{
+		{BaseDN: "ou=People,dc=example,dc=org", Scope: "one"},
+		{BaseDN: "ou=Contractors,dc=example,dc=org"},
+		// Overlaps with the bases above.
+		{BaseDN: "dc=example,dc=org", Scope: "one"},
+	}
+	cfg.Groups.SearchBases = []LdapSearchBase{
+		{BaseDN: "dc=example,dc=org"},
+		{BaseDN: "ou=Teams,dc=other,dc=org", Scope: "one"},
+	}
+	source := newFakeLdap(t, cfg)
+
+	users, err := source.GetUsers()
+	require.NoError(t, err)
+	var userIDs []string
+	for _, user := range users {
+		userIDs = append(userIDs, user.GetID())
+	}
+	require.Equal(t, []string{"alice", "bob"}, userIDs)
+
+	groups, err := source.GetGroupsWithMembers()
+	require.NoError(t, err)
+	var groupIDs []string
+	for _, group := range groups {
+		groupIDs = append(groupIDs, group.SourceGroup.GetID())
+	}
+	require.Equal(t, []string{"devs", "qa"}, groupIDs)
+
+	cfg = newFakeLdapConfig(server.url())
+	cfg.Users.SearchBases = []LdapSearchBase{{BaseDN: "dc=example,dc=org", Scope: "children"}}
+	_, err = NewLdap(cfg, getDevelopmentLogger())
+	require.ErrorContains(t, err, "unknown scope")
+}
+
+func TestLdapRangedMembers(t *testing.T) {
+	server := newFakeLdapServer(t)
+	server.maxValRange = 3
+	var memberDNs, memberUIDs []string
+	expectedMembers := NewStringSet()
+	for i := 0; i < 8; i++ {
+		uid := fmt.Sprintf("user%d", i)
+		addFakeLdapUser(server, uid)
+		memberDNs = append(memberDNs, fmt.Sprintf("uid=%s,ou=People,dc=example,dc=org", uid))
+		memberUIDs = append(memberUIDs, uid)
+		expectedMembers.Add(uid)
+	}
+	server.addEntry("cn=devs,ou=Group,dc=example,dc=org", map[string][]string{
+		"objectClass": {"top", "groupOfNames", "posixGroup"},
+		"cn":          {"devs"},
+		"member":      memberDNs,
+		"memberUid":   memberUIDs,
+	})
+
+	for _, tc := range []struct {
+		membershipType  string
+		memberAttribute string
+	}{
+		{membershipType: "dn", memberAttribute: "member"},
+		{membershipType: "uid", memberAttribute: "memberUid"},
+	} {
+		t.Run(tc.membershipType, func(t *testing.T) {
+			cfg := newFakeLdapConfig(server.url())
+			cfg.Groups.MembershipType = tc.membershipType
+			cfg.Groups.MemberUIDAttributeType = tc.memberAttribute
+			source := newFakeLdap(t, cfg)
+
+			_, err := source.GetUsers()
+			require.NoError(t, err)
+			groups, err := source.GetGroupsWithMembers()
+			require.NoError(t, err)
+			require.Len(t, groups, 1)
+			require.Equal(t, expectedMembers, groups[0].Members)
+		})
+	}
+}
+
+func TestLdapIncrementalSync(t *testing.T) {
+	server := newFakeLdapServer(t)
+	addUser := func(uid, givenName, modifyTimestamp string) {
+		server.removeEntry(fmt.Sprintf("uid=%s,ou=People,dc=example,dc=org", uid))
+		addFakeLdapUserWithAttributes(server, uid, map[string][]string{
+			"givenName":       {givenName},
+			"modifyTimestamp": {modifyTimestamp},
+		})
+	}
+	getFirstNames := func(source *Ldap) map[string]string {
+		users, err := source.GetUsers()
+		require.NoError(t, err)
+		firstNames := make(map[string]string)
+		for _, user := range users {
+			firstNames[user.GetID()] = user.(LdapUser).FirstName
+		}
+		return firstNames
+	}
+	addUser("alice", "alice", "20240101000000Z")
+	addUser("bob", "bob", "20240101000000Z")
+
+	cfg := newFakeLdapConfig(server.url())
+	cfg.Incremental = &LdapIncrementalConfig{}
+	source := newFakeLdap(t, cfg)
+	passiveClock := testclock.NewFakePassiveClock(initialTestTime)
+	source.clock = passiveClock
+
+	require.Equal(t, map[string]string{"alice": "alice", "bob": "bob"}, getFirstNames(source))
+
+	addUser("alice", "alicia", "20240102000000Z")
+	addUser("carol", "carol", "20240102000000Z")
+	server.removeEntry("uid=bob,ou=People,dc=example,dc=org")
+	// Dave's change value is not updated, he is fetched separately.
+	addUser("dave", "dave", "20231231000000Z")
+	require.Equal(t, map[string]string{"alice": "alicia", "carol": "carol", "dave": "dave"}, getFirstNames(source))
+
+	searches := server.getSearches()
+	require.Len(t, searches, 4)
+	require.Equal(t, cfg.Users.Filter, searches[0].Filter)
+	require.Equal(t, cfg.Users.Filter, searches[1].Filter)
+	require.Equal(t, fmt.Sprintf("(&%s(modifyTimestamp>=20240101000000Z))", cfg.Users.Filter), searches[2].Filter)
+	require.Equal(t, "uid=dave,ou=People,dc=example,dc=org", searches[3].BaseDN)
+
+	// Nothing has changed since the previous sync.
+	require.Equal(t, map[string]string{"alice": "alicia", "carol": "carol", "dave": "dave"}, getFirstNames(source))
+	searches = server.getSearches()
+	require.Len(t, searches, 6)
+	require.Equal(t, fmt.Sprintf("(&%s(modifyTimestamp>=20240102000000Z))", cfg.Users.Filter), searches[5].Filter)
+
+	// Full sync is forced periodically.
+	passiveClock.SetTime(initialTestTime.Add(25 * time.Hour))
+	require.Equal(t, map[string]string{"alice": "alicia", "carol": "carol", "dave": "dave"}, getFirstNames(source))
+	searches = server.getSearches()
+	require.Len(t, searches, 7)
+	require.Equal(t, cfg.Users.Filter, searches[6].Filter)
+}
+
+func TestLdapCompareChangeValues(t *testing.T) {
+	require.Equal(t, 1, compareLdapChangeValues("12000", "9000"))
+	require.Equal(t, -1, compareLdapChangeValues("20231231000000Z", "20240101000000Z"))
+	require.Equal(t, 1, compareLdapChangeValues("1", ""))
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/pkg/errors"
@@ -12,11 +14,56 @@ var options struct {
 	ConfigFile string `long:"config" description:"Config file path" required:"true"`
 }
 
+// ldapDiscoverCommand is `ldap discover` subcommand, without subcommands the sync is started.
+type ldapDiscoverCommand struct {
+	SampleSize int `long:"sample-size" description:"Number of entries sampled per search" default:"5"`
+}
+
+func (c *ldapDiscoverCommand) Execute(_ []string) error {
+	return runLdapDiscover(options.ConfigFile, c.SampleSize, os.Stdout)
+}
+
 func main() {
-	_, err := flags.Parse(&options)
+	parser := flags.NewParser(&options, flags.Default)
+	parser.SubcommandsOptional = true
+	ldapCommand, err := parser.AddCommand("ldap", "LDAP source tools", "", &struct{}{})
+	if err != nil {
+		panic("failed to add ldap command: " + err.Error())
+	}
+	_, err = ldapCommand.AddCommand(
+		"discover",
+		"Inspect LDAP directory and propose config",
+		"Binds with the ldap config credentials, reads root DSE and schema, samples entries matching the filters "+
+			"and prints suggested ldap config along with users and groups it produces.",
+		&ldapDiscoverCommand{},
+	)
+	if err != nil {
+		panic("failed to add ldap discover command: " + err.Error())
+	}
+	// Subcommand errors are returned by Parse too, so they are kept apart from options parsing errors.
+	var commandErr error
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if command != nil {
+			commandErr = command.Execute(args)
+		}
+		return nil
+	}
+	_, err = parser.Parse()
 	if err != nil {
 		panic("failed to parse options: " + err.Error())
 	}
+	if parser.Active != nil {
+		// Subcommand has been executed.
+		if commandErr != nil {
+			var names []string
+			for command := parser.Active; command != nil; command = command.Active {
+				names = append(names, command.Name)
+			}
+			_, _ = fmt.Fprintf(os.Stderr, "%s failed: %s\n", strings.Join(names, " "), commandErr)
+			os.Exit(1)
+		}
+		return
+	}
 
 	err = run(options.ConfigFile)
 	if err != nil {
@@ -45,19 +45,7 @@ func NewYtsaurus(cfg *YtsaurusConfig, logger appLoggerType, clock clock.PassiveC
 		}
 	}
 
-	if cfg.SecretEnvVar == "" {
-		cfg.SecretEnvVar = defaultYtsaurusSecretEnvVar
-	}
-	secret := os.Getenv(cfg.SecretEnvVar)
-	if secret == "" {
-		return nil, errors.Errorf("YTsaurus secret in %s env var shouldn't be empty", cfg.SecretEnvVar)
-	}
-	client, err := ythttp.NewClient(&yt.Config{
-		Proxy: cfg.Proxy,
-		Credentials: &yt.TokenCredentials{
-			Token: secret,
-		},
-	})
+	client, err := newYtsaurusClient(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -83,6 +71,22 @@ func NewYtsaurus(cfg *YtsaurusConfig, logger appLoggerType, clock clock.PassiveC
 	}, nil
 }
 
+func newYtsaurusClient(cfg *YtsaurusConfig) (yt.Client, error) {
+	if cfg.SecretEnvVar == "" {
+		cfg.SecretEnvVar = defaultYtsaurusSecretEnvVar
+	}
+	secret := os.Getenv(cfg.SecretEnvVar)
+	if secret == "" {
+		return nil, errors.Errorf("YTsaurus secret in %s env var shouldn't be empty", cfg.SecretEnvVar)
+	}
+	return ythttp.NewClient(&yt.Config{
+		Proxy: cfg.Proxy,
+		Credentials: &yt.TokenCredentials{
+			Token: secret,
+		},
+	})
+}
+
 func (y *Ytsaurus) GetUsers() ([]YtsaurusUser, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), y.timeout)
 	defer cancel()
@@ -337,6 +341,26 @@ func (y *Ytsaurus) RemoveMember(username, groupname string) error {
 	return doRemoveMemberYtsaurusGroup(ctx, y.client, username, groupname)
 }
 
+// SetGroupManagers replaces the managers ACE, which was added by the sync, in the group's @acl.
+// Other ACEs are kept as is, empty managers remove the ACE.
+func (y *Ytsaurus) SetGroupManagers(groupname string, managers StringSet) error {
+	logger := y.logger.With("groupname", groupname, "managers", managers)
+	if y.dryRunGroups {
+		logger.Debugw("[DRY-RUN] Going to set group managers")
+		return nil
+	}
+	if err := y.ensureGroupManaged(groupname); err != nil {
+		return err
+	}
+	logger.Debugw("Going to set group managers")
+
+	ctx, cancel := context.WithTimeout(context.Background(), y.timeout)
+	defer cancel()
+
+	y.maybePrintExtraLogs(groupname, "set_group_managers", "groupname", groupname, "managers", managers)
+	return doSetYtsaurusGroupManagers(ctx, y.client, groupname, managers)
+}
+
 func (y *Ytsaurus) isUserManaged(username string) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), y.timeout)
 	defer cancel()
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.ytsaurus.tech/yt/go/ypath"
+	"go.ytsaurus.tech/yt/go/yson"
 	"go.ytsaurus.tech/yt/go/yt"
 )
 
@@ -15,7 +17,12 @@ const (
 	bannedSinceAttributeName = "banned_since"
 	bannedAttributeName      = "banned"
 	membersAttributeName     = "members"
+	aclAttributeName         = "acl"
 	nameAttributeName        = "name"
+	// syncedManagersAttributeName keeps subjects of the managers ACE, which was added into group's @acl by the sync.
+	syncedManagersAttributeName = "synced_managers"
+
+	ytsaurusObjectOnlyInheritanceMode = "object_only"
 )
 
 var (
@@ -85,6 +92,7 @@ func doGetAllYtsaurusGroupsWithMembers(ctx context.Context, client yt.Client, so
 			Attributes: []string{
 				membersAttributeName,
 				sourceAttributeName,
+				syncedManagersAttributeName,
 			},
 		},
 	)
@@ -95,6 +103,7 @@ func doGetAllYtsaurusGroupsWithMembers(ctx context.Context, client yt.Client, so
 	var groups []YtsaurusGroupWithMembers
 	for _, ytGroup := range response {
 		members := NewStringSet()
+		var managers StringSet
 
 		group := YtsaurusGroup{Name: ytGroup.Name}
 
@@ -108,11 +117,20 @@ func doGetAllYtsaurusGroupsWithMembers(ctx context.Context, client yt.Client, so
 			if sourceRaw, ok := ytGroup.Attrs[sourceAttributeName]; ok {
 				group.SourceRaw = sourceRaw.(map[string]any)
 			}
+
+			if managersRaw, ok := ytGroup.Attrs[syncedManagersAttributeName]; ok {
+				var subjects []string
+				if err = convertYson(managersRaw, &subjects); err != nil {
+					return nil, errors.Wrapf(err, "failed to parse @%s of group %s", syncedManagersAttributeName, ytGroup.Name)
+				}
+				managers = NewStringSetFromItems(subjects...)
+			}
 		}
 
 		groups = append(groups, YtsaurusGroupWithMembers{
 			YtsaurusGroup: group,
 			Members:       members,
+			Managers:      managers,
 		})
 	}
 	return groups, nil
@@ -238,3 +256,83 @@ func doSetAttributesForYtsaurusGroupUpdate(ctx context.Context, client yt.Client
 		nil,
 	)
 }
+
+// isYtsaurusGroupManagersACE is true for the ACE which was added by the sync for the managers recorded
+// in @synced_managers: it allows only `write` (which is required to change members) to exactly these subjects
+// and isn't inherited. ACEs of the same shape which are written by admins for other subjects are never touched.
+func isYtsaurusGroupManagersACE(ace yt.ACE, managers StringSet) bool {
+	return ace.Action == yt.ActionAllow &&
+		len(ace.Permissions) == 1 && ace.Permissions[0] == yt.PermissionWrite &&
+		ace.InheritanceMode == ytsaurusObjectOnlyInheritanceMode &&
+		len(ace.Columns) == 0 && ace.SubjectTagFilter == "" &&
+		NewStringSetFromItems(ace.Subjects...).Equal(managers)
+}
+
+// buildYtsaurusGroupACL replaces the managers ACE of oldManagers with the one of managers in the ACL,
+// other ACEs are kept as is. Only one ACE is replaced, even if an admin has written the same one.
+func buildYtsaurusGroupACL(acl []yt.ACE, oldManagers StringSet, managers StringSet) []yt.ACE {
+	result := make([]yt.ACE, 0, len(acl)+1)
+	removed := oldManagers.Cardinality() == 0
+	for _, ace := range acl {
+		if !removed && isYtsaurusGroupManagersACE(ace, oldManagers) {
+			removed = true
+			continue
+		}
+		result = append(result, ace)
+	}
+	if managers.Cardinality() > 0 {
+		result = append(result, yt.ACE{
+			Action:          yt.ActionAllow,
+			Subjects:        sortedYtsaurusSubjects(managers),
+			Permissions:     []yt.Permission{yt.PermissionWrite},
+			InheritanceMode: ytsaurusObjectOnlyInheritanceMode,
+		})
+	}
+	return result
+}
+
+func sortedYtsaurusSubjects(subjects StringSet) []string {
+	result := subjects.ToSlice()
+	sort.Strings(result)
+	return result
+}
+
+func doSetYtsaurusGroupManagers(ctx context.Context, client yt.Client, groupname string, managers StringSet) error {
+	attrsPath := "//sys/groups/" + groupname + "/@"
+	var acl []yt.ACE
+	if err := client.GetNode(ctx, ypath.Path(attrsPath+aclAttributeName), &acl, nil); err != nil {
+		return err
+	}
+	oldManagers := NewStringSet()
+	managersPath := ypath.Path(attrsPath + syncedManagersAttributeName)
+	exists, err := client.NodeExists(ctx, managersPath, nil)
+	if err != nil {
+		return err
+	}
+	if exists {
+		var subjects []string
+		if err = client.GetNode(ctx, managersPath, &subjects, nil); err != nil {
+			return err
+		}
+		oldManagers = NewStringSetFromItems(subjects...)
+	}
+	// ACL and the record of the sync's ACE are changed at once, so they can't diverge.
+	return client.MultisetAttributes(
+		ctx,
+		ypath.Path(attrsPath),
+		map[string]any{
+			aclAttributeName:            buildYtsaurusGroupACL(acl, oldManagers, managers),
+			syncedManagersAttributeName: sortedYtsaurusSubjects(managers),
+		},
+		nil,
+	)
+}
+
+// convertYson converts generic yson value (e.g. attribute of listed nodes) into the typed one.
+func convertYson(value any, result any) error {
+	content, err := yson.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return yson.Unmarshal(content, result)
+}
@@ -42,6 +42,9 @@ type YtsaurusGroupWithMembers struct {
 	YtsaurusGroup
 	// Members is a set of group members' @name attribute.
 	Members StringSet
+	// Managers is a set of subjects of the managers ACE, which the sync has added into group's @acl.
+	// It is recorded in @synced_managers, nil if the sync has never set managers of the group.
+	Managers StringSet
 }
 
 func NewEmptyYtsaurusGroupWithMembers(group YtsaurusGroup) YtsaurusGroupWithMembers {
@@ -52,3 +55,9 @@ type YtsaurusMembership struct {
 	GroupName string
 	Username  string
 }
+
+// YtsaurusGroupManagers are users which are allowed to manage the group, empty set means no managers ACE.
+type YtsaurusGroupManagers struct {
+	GroupName string
+	Usernames StringSet
+}
@@ -209,3 +209,54 @@ func TestUpdateGroup(t *testing.T) {
 	}
 	require.Equal(t, managedOlegsGroup, fetchedGroup)
 }
+
+func TestGroupManagers(t *testing.T) {
+	ctx := context.Background()
+	ytLocal, err := ytcontainer.RunContainer(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, ytLocal.Terminate(ctx)) }()
+	yt := getYtsaurus(t, ytLocal)
+
+	for _, username := range []string{"alice", "bob"} {
+		require.NoError(t, yt.CreateUser(YtsaurusUser{Username: username, SourceRaw: map[string]any{"id": username}}))
+	}
+	group := YtsaurusGroup{Name: "devs", SourceRaw: map[string]any{"id": "fake-az-id-devs"}}
+	require.NoError(t, yt.CreateGroup(group))
+	// ACEs which aren't owned by the sync are kept, even if they have the same shape.
+	adminsACE := map[string]any{"action": "allow", "subjects": []string{"admins"}, "permissions": []string{"write"}}
+	bobACE := map[string]any{
+		"action":           "allow",
+		"subjects":         []string{"bob"},
+		"permissions":      []string{"write"},
+		"inheritance_mode": ytsaurusObjectOnlyInheritanceMode,
+	}
+	aclPath := ypath.Path("//sys/groups/devs/@acl")
+	require.NoError(t, yt.client.SetNode(ctx, aclPath, []any{adminsACE, bobACE}, nil))
+
+	getManagers := func() StringSet {
+		groups, err := yt.GetGroupsWithMembers()
+		require.NoError(t, err)
+		for _, ytGroup := range groups {
+			if ytGroup.Name == group.Name {
+				return ytGroup.Managers
+			}
+		}
+		t.Fatalf("group %s is not found", group.Name)
+		return nil
+	}
+	require.Nil(t, getManagers())
+
+	require.NoError(t, yt.SetGroupManagers(group.Name, NewStringSetFromItems("alice", "bob")))
+	require.Equal(t, NewStringSetFromItems("alice", "bob"), getManagers())
+
+	require.NoError(t, yt.SetGroupManagers(group.Name, NewStringSetFromItems("bob")))
+	require.Equal(t, NewStringSetFromItems("bob"), getManagers())
+
+	require.NoError(t, yt.SetGroupManagers(group.Name, NewStringSet()))
+	require.Empty(t, getManagers().ToSlice())
+	var acl []map[string]any
+	require.NoError(t, yt.client.GetNode(ctx, aclPath, &acl, nil))
+	require.Len(t, acl, 2)
+	require.Equal(t, []any{"admins"}, acl[0]["subjects"])
+	require.Equal(t, []any{"bob"}, acl[1]["subjects"])
+}